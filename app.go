@@ -1,290 +1,2255 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 const (
 	exitFail = 1
+	// maxRequestIDLength bounds the length of a reused X-Request-ID header
+	maxRequestIDLength = 128
+	// catchAllRepo is the repo of mappings used for repos without mapping
+	catchAllRepo = "*"
+	// endpointBuild and endpointBuildWithParameters are the jenkins
+	// endpoints triggering builds
+	endpointBuild               = "build"
+	endpointBuildWithParameters = "buildWithParameters"
+	// defaultMaxBodyBytes is the default limit of incoming request bodies
+	defaultMaxBodyBytes = 1 << 20
+	// defaultAddr is the address the server listens on
+	defaultAddr = ":8080"
+	// tagPrefix marks the branch of tag pushes and of mappings for tags
+	tagPrefix = "tag:"
+	// defaultBranchRef is the branch of mappings for pushes to the default
+	// branch of the repo
+	defaultBranchRef = "@default"
+	// anyBranch is the branch of mappings for pushes to any branch, entries
+	// without branch are stored with it as well
+	anyBranch = "*"
+	// defaultBuildPathTemplate is the path jenkins triggers builds at
+	defaultBuildPathTemplate = "{jobpath}/{action}"
 )
 
 var (
-	mapping    = make(map[string][]string)
-	timeKeeper = make(map[string]*time.Timer)
-
-	JenkinsURL   string
-	JenkinsUser  string
-	JenkinsToken string
-	JenkinsMulti string
-	MappingFile  string
-	QuietPeriod  int
-	FileMatching bool
+	rootCAs *x509.CertPool
+	// allowedMethods holds the methods of AllowedMethods /trigger accepts
+	allowedMethods = []string{http.MethodGet, http.MethodPost}
+	// csvDelimiter is the field delimiter of CSV mapping files as given by
+	// CSVDelimiter
+	csvDelimiter = ';'
+	// httpProxyURL is the parsed HTTPProxy, the proxy of the environment is
+	// used if it is nil
+	httpProxyURL *url.URL
+	// retryBackoff is the delay before the first retry, it doubles with
+	// every further attempt
+	retryBackoff = time.Second
+
+	JenkinsURL         string
+	JenkinsUser        string
+	JenkinsToken       string
+	JenkinsTokenFile   string
+	JenkinsMulti       string
+	WebhookSecret      string
+	AllowedRepos       string
+	MappingFile        string
+	QuietPeriod        time.Duration
+	RequestTimeout     time.Duration
+	InsecureSkipVerify bool
+	CACert             string
+	MaxRetries         int
+	MaxRetryAfter      time.Duration
+	UseCrumb           bool
+	WatchMapping       bool
+	DryRun             bool
+	FlushOnShutdown    bool
+	Once               bool
+	DefaultBranch      = "master"
+	NormalizeRepo      bool
+	TrackBuilds        bool
+	ValidateOnly       bool
+	PrintConfig        bool
+	CaseInsensitive    bool
+	LogFormat          string
+	LogLevel           string
+	Verbose            bool
+	Quiet              bool
+	GracePeriod        time.Duration
+	MappingsToken      string
+	CSVDelimiter       string
+	MaxConcurrency     int
+	CatchAll           bool
+	TriggerNowToken    string
+	AllowEmptyMapping  bool
+	IncomingToken      string
+	BuildPathTemplate  string
+	ConfigFile         string
+	MaxBodyBytes       int64
+	DebugResponses     bool
+	ShutdownTimeout    time.Duration
+	RateLimit          float64
+	RateLimitBurst     int
+	MaxWait            time.Duration
+	DedupeWindow       time.Duration
+	Sync               bool
+	SyncTimeout        time.Duration
+	FileMatching       bool
+	JenkinsHeaders     = headerFlag{}
+	Cause              string
+	UnhealthyAfter     int
+	BasePath           string
+	ValidateJobs       bool
+	Strict             bool
+	TrustProxy         bool
+	TLSCert            string
+	TLSKey             string
+	AllowedMethods     string
+	BreakerThreshold   int
+	BreakerCooldown    time.Duration
+	BreakerMode        string
+	HTTPProxy          string
 )
 
 type triggerMapping struct {
-	mapping map[string][]string
+	mapping map[string][]jobMapping
+	// patterns holds the wildcard branch patterns per repo
+	patterns map[string][]string
 }
 
-func triggerJob(job string) bool {
-	url := createJobURL(JenkinsURL, job)
+// add adds the jobs of the entry to the mapping
+func (tm *triggerMapping) add(entry mappingEntry, filematch bool) error {
+	jobs, err := entry.jobMappings(filematch)
+	if err != nil {
+		return err
+	}
+
+	key := entry.key()
+
+	if isBranchPattern(entry.Branch) && !isAnyBranch(entry.Branch) {
+		if tm.patterns == nil {
+			tm.patterns = make(map[string][]string)
+		}
+		if len(tm.mapping[key]) == 0 {
+			repo := normalizeCase(entry.Repo)
+			tm.patterns[repo] = append(tm.patterns[repo], normalizeCase(entry.Branch))
+		}
+	}
+
+	for _, job := range jobs {
+		tm.addJob(key, job)
+	}
+
+	return nil
+}
+
+// merge adds the jobs and branch patterns of other to the mapping, jobs of
+// keys present in both are appended
+func (tm *triggerMapping) merge(other triggerMapping) {
+	for key, jobs := range other.mapping {
+		for _, job := range jobs {
+			tm.addJob(key, job)
+		}
+	}
+
+	for repo, patterns := range other.patterns {
+		if tm.patterns == nil {
+			tm.patterns = make(map[string][]string)
+		}
+		for _, pattern := range patterns {
+			if !slices.Contains(tm.patterns[repo], pattern) {
+				tm.patterns[repo] = append(tm.patterns[repo], pattern)
+			}
+		}
+	}
+}
+
+// jobCount returns the number of jobs over all keys
+func (tm *triggerMapping) jobCount() int {
+	count := 0
+	for _, jobs := range tm.mapping {
+		count += len(jobs)
+	}
+
+	return count
+}
+
+// addJob adds the job to the key. The rules of a job listed again are
+// added to the existing job, if either of them has no rules the job matches
+// any push regardless of the order of the rows. A duplicate without rules
+// of a job without rules is ignored.
+func (tm *triggerMapping) addJob(key string, job jobMapping) {
+	for i, mapped := range tm.mapping[key] {
+		if mapped.timerKey() != job.timerKey() {
+			continue
+		}
+		switch {
+		case len(mapped.Rules) == 0 && len(job.Rules) == 0:
+			slog.Warn("Ignoring duplicate job in mapping", "key", key, "job", job.Name)
+			return
+		case len(mapped.Rules) == 0 || len(job.Rules) == 0:
+			mapped.Rules = nil
+		default:
+			for _, rule := range job.Rules {
+				mapped.Rules = addRule(mapped.Rules, rule)
+			}
+		}
+		tm.mapping[key][i] = mapped
+		return
+	}
+
+	tm.mapping[key] = append(tm.mapping[key], job)
+}
+
+// addRule adds the rule to a copy of the rules, its file patterns are added
+// to a rule with the same change types if there is one. The rules of the
+// jobs of one row share their slices.
+func addRule(rules []fileRule, rule fileRule) []fileRule {
+	rules = slices.Clone(rules)
+	for i, existing := range rules {
+		if !slices.Equal(existing.ChangeTypes, rule.ChangeTypes) {
+			continue
+		}
+		// a rule without patterns already matches any file
+		if len(existing.FilePatterns) == 0 || len(rule.FilePatterns) == 0 {
+			rules[i].FilePatterns = nil
+			return rules
+		}
+		for _, pattern := range rule.FilePatterns {
+			if !slices.Contains(existing.patternStrings(), pattern.String()) {
+				existing.FilePatterns = append(slices.Clip(existing.FilePatterns), pattern)
+			}
+		}
+		rules[i] = existing
+		return rules
+	}
+
+	return append(rules, rule)
+}
+
+func isBranchPattern(branch string) bool {
+	return strings.ContainsAny(branch, "*?[")
+}
+
+// isAnyBranch reports whether the branch of a mapping entry matches pushes
+// to any branch
+func isAnyBranch(branch string) bool {
+	return branch == "" || branch == anyBranch
+}
+
+// mappingKeysForRepo returns the sorted mapping keys of the repo
+func (s *Server) mappingKeysForRepo(repo string) []string {
+	s.mappingMu.RLock()
+	defer s.mappingMu.RUnlock()
+
+	prefix := BuildMappingKey([]string{repo, ""})
+	keys := []string{}
+	for key := range s.mapping {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// lookupJobs returns the jobs mapped to the repo and branch. A mapping for
+// the exact branch, or for @default if the branch is the default branch of
+// the repo, takes precedence, only if there is none the jobs of all
+// wildcard patterns matching the branch are returned and without those the
+// jobs mapped to any branch of the repo.
+func (s *Server) lookupJobs(logger *slog.Logger, repo, branch, defaultBranch string) []jobMapping {
+	s.mappingMu.RLock()
+	defer s.mappingMu.RUnlock()
+
+	exact := s.mapping[BuildMappingKey([]string{repo, branch})]
+	if isDefaultBranch(branch, defaultBranch) {
+		for _, job := range s.mapping[BuildMappingKey([]string{repo, defaultBranchRef})] {
+			if !slices.ContainsFunc(exact, func(j jobMapping) bool { return j.timerKey() == job.timerKey() }) {
+				// clipped to not append to the slice of the mapping
+				exact = append(slices.Clip(exact), job)
+			}
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+
+	var jobs []jobMapping
+	for _, pattern := range s.branchPatterns[normalizeCase(repo)] {
+		// tags only match tag patterns and branches only branch patterns
+		if isTag(pattern) != isTag(branch) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, normalizeCase(branch)); ok {
+			logger.Debug("Branch matches wildcard pattern", "branch", branch, "pattern", pattern)
+			jobs = append(jobs, s.mapping[BuildMappingKey([]string{repo, pattern})]...)
+		}
+	}
+
+	// tags are no branches, they only match tag mappings
+	if len(jobs) == 0 && !isTag(branch) {
+		jobs = s.mapping[BuildMappingKey([]string{repo, anyBranch})]
+	}
+
+	return jobs
+}
+
+// isDefaultBranch reports whether the branch of a push is the default
+// branch of the repo
+func isDefaultBranch(branch, defaultBranch string) bool {
+	return defaultBranch != "" && !isTag(branch) && normalizeCase(branch) == normalizeCase(defaultBranch)
+}
+
+// isTag reports whether the branch of a push or mapping entry is a tag
+func isTag(branch string) bool {
+	return strings.HasPrefix(branch, tagPrefix)
+}
+
+// jobMapping is a job referenced by a mapping entry
+type jobMapping struct {
+	Name string
+	// Params lists the build parameters of a parameterized job, either as
+	// NAME to pass the request parameter of that name or as NAME=value
+	Params []string
+	// Rules restrict the job to pushes matching any of them, a job without
+	// rules matches any push
+	Rules []fileRule
+	// QuietPeriod overrides the global quiet period if set
+	QuietPeriod *time.Duration
+	// Target overrides the global jenkins the job is triggered on if set
+	Target *jenkinsTarget
+	// Endpoint is the trigger endpoint, build or buildWithParameters, it is
+	// derived from Params if empty
+	Endpoint string
+}
+
+// fileRule restricts a job to pushes changing a file of its change types
+// which matches any of its file patterns. The rows of a job with the same
+// change types share one rule.
+type fileRule struct {
+	// FilePatterns are only set in file matching mode, a rule without
+	// patterns matches any file
+	FilePatterns []*regexp.Regexp
+	// ChangeTypes restrict the rule to files added, modified or deleted by
+	// the push, a rule without change types matches any change
+	ChangeTypes []string
+}
+
+// change types of mapping entries
+const (
+	changeAdd    = "add"
+	changeModify = "modify"
+	changeDelete = "delete"
+)
+
+// parseChangeTypes parses a comma separated list of change types, they are
+// returned in the order add, modify, delete
+func parseChangeTypes(value string) ([]string, error) {
+	given := make(map[string]bool)
+	for _, changeType := range strings.Split(value, ",") {
+		changeType = strings.TrimSpace(changeType)
+		switch changeType {
+		case "":
+		case changeAdd, changeModify, changeDelete:
+			given[changeType] = true
+		default:
+			return nil, fmt.Errorf("invalid change type %q, expected %s, %s or %s", changeType, changeAdd, changeModify, changeDelete)
+		}
+	}
+
+	var changeTypes []string
+	for _, changeType := range []string{changeAdd, changeModify, changeDelete} {
+		if given[changeType] {
+			changeTypes = append(changeTypes, changeType)
+		}
+	}
+
+	return changeTypes, nil
+}
+
+// target returns the jenkins the job is triggered on, defaults if its
+// mapping names none, a target without credentials uses the ones of defaults
+func (j jobMapping) target(defaults jenkinsTarget) jenkinsTarget {
+	if j.Target == nil {
+		return defaults
+	}
+
+	target := *j.Target
+	if target.User == "" && target.Token == "" {
+		target.User, target.Token = defaults.User, defaults.Token
+	}
+
+	return target
+}
+
+// timerKey identifies the pending trigger of the job, jobs of the same name
+// on different jenkins masters are distinct
+func (j jobMapping) timerKey() string {
+	if j.Target == nil {
+		return j.Name
+	}
+
+	return j.Target.URL + "|" + j.Name
+}
+
+// quietPeriod returns the quiet period of the job, defaultPeriod if its
+// mapping sets none
+func (j jobMapping) quietPeriod(defaultPeriod time.Duration) time.Duration {
+	if j.QuietPeriod != nil {
+		return *j.QuietPeriod
+	}
+
+	return defaultPeriod
+}
+
+// parseQuietPeriod parses a quiet period given as duration like 30s or as
+// plain number of seconds
+func parseQuietPeriod(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// quietPeriodFlag is a duration flag accepting plain numbers as seconds like
+// the former --quietperiod
+type quietPeriodFlag time.Duration
+
+func (f *quietPeriodFlag) String() string {
+	return time.Duration(*f).String()
+}
+
+func (f *quietPeriodFlag) Set(value string) error {
+	d, err := parseQuietPeriod(value)
+	if err != nil {
+		return err
+	}
+	*f = quietPeriodFlag(d)
+
+	return nil
+}
+
+// matchesChanges reports whether the push matches any rule of the job
+func (j jobMapping) matchesChanges(webhook *WebhookRequest) bool {
+	if len(j.Rules) == 0 {
+		return true
+	}
+
+	for _, rule := range j.Rules {
+		if rule.matches(webhook) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches reports whether the push changed a file of the change types of
+// the rule which matches its file patterns
+func (r fileRule) matches(webhook *WebhookRequest) bool {
+	files := webhook.Files
+	if len(r.ChangeTypes) > 0 {
+		if files = webhook.Changes.files(r.ChangeTypes); len(files) == 0 {
+			return false
+		}
+	}
+
+	if len(r.FilePatterns) == 0 {
+		return true
+	}
+
+	for _, file := range files {
+		for _, pattern := range r.FilePatterns {
+			if pattern.MatchString(file) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// patternStrings returns the file patterns of the rule as strings
+func (r fileRule) patternStrings() []string {
+	var patterns []string
+	for _, pattern := range r.FilePatterns {
+		patterns = append(patterns, pattern.String())
+	}
+
+	return patterns
+}
+
+// buildParams returns the build parameters for the job or nil if the job is
+// not parameterized
+func (j jobMapping) buildParams(reqParams url.Values) url.Values {
+	if len(j.Params) == 0 {
+		// jobs triggered remotely by token might need buildWithParameters
+		// without having any parameter
+		if j.Endpoint == endpointBuildWithParameters {
+			return url.Values{}
+		}
+		return nil
+	}
+
+	params := url.Values{}
+	for _, param := range j.Params {
+		if name, value, ok := strings.Cut(param, "="); ok {
+			params.Set(name, value)
+		} else if values, ok := reqParams[param]; ok {
+			params[param] = values
+		}
+	}
+
+	return params
+}
+
+// statusError is returned by triggerJob if jenkins answered the trigger
+// request with a non 2xx status
+type statusError struct {
+	Status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("jenkins responded with status code %d", e.Status)
+}
+
+// triggerJob triggers the job on the target and retries failed attempts.
+// It returns the status code and the Location of the last response, the
+// queue item of the build for 201 responses, a *statusError if jenkins
+// rejected the trigger and any other error if jenkins could not be reached.
+func (s *Server) triggerJob(ctx context.Context, target jenkinsTarget, job string, params url.Values, cause string) (int, string, error) {
+	logger := loggerFrom(ctx)
+
+	if DryRun {
+		logger.Info(fmt.Sprintf("[DRY-RUN] would trigger %s at %s", job, triggerURL(target, job, params)),
+			"event", "job_dry_run", "job", job)
+		return 0, "", nil
+	}
+
+	if s.breaker != nil {
+		if err := s.breaker.wait(ctx, breakerKey(target)); err != nil {
+			jobsTriggered.inc(job, "skipped")
+			return 0, "", err
+		}
+	}
+
+	var status int
+	var header http.Header
+	var err error
+
+	attempts := 0
+	for {
+		attempts++
+		status, header, err = s.sendTrigger(ctx, target, job, params, cause)
+
+		if err == nil && status < 500 {
+			break
+		}
+
+		if attempts > MaxRetries {
+			break
+		}
+
+		backoff := retryBackoff * time.Duration(1<<(attempts-1))
+		// jenkins announces how long it is unavailable while restarting
+		if status == http.StatusServiceUnavailable {
+			if wait, ok := parseRetryAfter(header.Get("Retry-After"), time.Now()); ok {
+				backoff = min(wait, MaxRetryAfter)
+			}
+		}
+		if err != nil {
+			logger.Warn("Triggering job failed, retrying", "event", "job_trigger_retry",
+				"job", job, "attempt", attempts, "error", err, "backoff", backoff)
+		} else {
+			logger.Warn("Triggering job failed, retrying", "event", "job_trigger_retry",
+				"job", job, "attempt", attempts, "status", status, "backoff", backoff)
+		}
+		select {
+		case <-time.After(backoff):
+			continue
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		break
+	}
+
+	if s.breaker != nil {
+		s.breaker.record(breakerKey(target), err == nil && status < 500)
+	}
+
+	location := header.Get("Location")
+
+	if err != nil {
+		jobsTriggered.inc(job, "failure")
+		return 0, "", fmt.Errorf("sending trigger request failed after %d attempt(s): %w", attempts, err)
+	}
+
+	if !triggerAccepted(status, location) {
+		jobsTriggered.inc(job, "failure")
+		return status, "", &statusError{Status: status}
+	}
+
+	logger.Info("Job triggered", "event", "job_triggered", "job", job, "status", status)
+	jobsTriggered.inc(job, "success")
+
+	return status, location, nil
+}
+
+// triggerAccepted reports whether jenkins queued the build, it answers with
+// 201 Created or with a redirect to the job for some older versions
+func triggerAccepted(status int, location string) bool {
+	if 300 <= status && status <= 399 {
+		return location != ""
+	}
+
+	return 200 <= status && status <= 299
+}
+
+// isQueueItem reports whether the Location of an accepted trigger points
+// to the queue item of the build, for redirects it points to the job page
+func isQueueItem(status int, location string) bool {
+	return status < 300 && location != ""
+}
+
+// logTriggerError logs the failure of triggerJob for the job
+func logTriggerError(logger *slog.Logger, job string, err error) {
+	var serr *statusError
+	if errors.As(err, &serr) {
+		logger.Error("Jenkins rejected the trigger", "event", "job_trigger_failed", "job", job, "status", serr.Status)
+		return
+	}
+
+	logger.Error("Triggering job failed", "event", "job_trigger_failed", "job", job, "error", err)
+}
+
+// sendTrigger sends a single trigger request for the job and returns the
+// status code and the header of the response, its Location points to the
+// queue item of the build
+func (s *Server) sendTrigger(ctx context.Context, target jenkinsTarget, job string, params url.Values, cause string) (int, http.Header, error) {
+	jobURL := triggerURL(target, job, params)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", jobURL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	setJenkinsHeaders(req)
+
+	query := req.URL.Query()
+	// if user and token is defined, use it for basic auth
+	if target.User != "" {
+		req.SetBasicAuth(target.User, target.Token)
+	} else {
+		// otherwise use the token for the direct build trigger
+		query.Set("token", target.Token)
+	}
+	if cause != "" {
+		query.Set("cause", cause)
+	}
+	req.URL.RawQuery = query.Encode()
+
+	// jenkins might require a crumb for anonymous triggers as well
+	if UseCrumb {
+		crumb, err := s.jenkins.getCrumb(ctx, target)
+		if err != nil {
+			return 0, nil, err
+		}
+		req.Header.Set(crumb.Field, crumb.Value)
+	}
+
+	// jenkins answers some triggers with a redirect to the job, following
+	// it would replace the response of the trigger with the one of the page
+	client := newJenkinsClient(s.jenkins.cookies)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	// the crumb might have expired, fetch a new one next time
+	if UseCrumb && resp.StatusCode == http.StatusForbidden {
+		s.jenkins.resetCrumb(target)
+	}
+
+	return resp.StatusCode, resp.Header, nil
+}
+
+// causeFor returns the build cause for a push to the branch of the repo
+// according to Cause, {repo} and {branch} are replaced
+func causeFor(repo, branch string) string {
+	return strings.NewReplacer("{repo}", repo, "{branch}", branch).Replace(Cause)
+}
+
+// triggerURL returns the url to trigger the job with the given parameters
+func triggerURL(target jenkinsTarget, job string, params url.Values) string {
+	if params != nil {
+		return createParamJobURL(target.URL, job, params)
+	}
+
+	return createJobURL(target.URL, job)
+}
+
+func createJobURL(jenkinsURL, job string) string {
+	return joinURL(jenkinsURL, buildPath(job, endpointBuild))
+}
+
+func createParamJobURL(jenkinsURL, job string, params url.Values) string {
+	jobURL := joinURL(jenkinsURL, buildPath(job, endpointBuildWithParameters))
+	if len(params) == 0 {
+		return jobURL
+	}
+
+	u, err := url.Parse(jobURL)
+	if err != nil {
+		return jobURL + "?" + params.Encode()
+	}
+	query := u.Query()
+	for name, values := range params {
+		query[name] = append(query[name], values...)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// buildPath returns the path to trigger the job with the given action
+// according to BuildPathTemplate. {jobpath} is replaced by the path with a
+// /job/ segment per folder level, {job} by the escaped job name and {action}
+// by build or buildWithParameters.
+func buildPath(job, action string) string {
+	template := BuildPathTemplate
+	if template == "" {
+		template = defaultBuildPathTemplate
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(strings.Trim(job, "/"), "/") {
+		segments = append(segments, url.PathEscape(segment))
+	}
+
+	return strings.NewReplacer(
+		"{jobpath}", jobPath(job),
+		"{job}", strings.Join(segments, "/"),
+		"{action}", action,
+	).Replace(template)
+}
+
+// validateBuildPathTemplate checks that the template references the job
+func validateBuildPathTemplate(template string) error {
+	if !strings.Contains(template, "{jobpath}") && !strings.Contains(template, "{job}") {
+		return fmt.Errorf("build path template %q contains neither {jobpath} nor {job}", template)
+	}
+
+	return nil
+}
+
+// multibranchURL returns the url of the multibranch project, projects in
+// folders are given as folder/project like jobs
+func multibranchURL(jenkinsURL, multi string) string {
+	return joinURL(jenkinsURL, jobPath(multi))
+}
+
+// jobPath returns the escaped url path of the job, jobs in folders are
+// given as folder/job and need a /job/ segment per level
+func jobPath(job string) string {
+	var path string
+	for _, segment := range strings.Split(strings.Trim(job, "/"), "/") {
+		path += "/job/" + url.PathEscape(segment)
+	}
+
+	return path
+}
+
+// pendingTrigger is a job waiting for its quiet period to pass
+type pendingTrigger struct {
+	timer *time.Timer
+	// repo and branch are the push the trigger originates from
+	repo   string
+	branch string
+	job    jobMapping
+	params url.Values
+	// deadline is the latest time the job is triggered at, it is zero if
+	// the wait is not capped
+	deadline time.Time
+	// fireAt is the time the timer fires at
+	fireAt time.Time
+	// logger carries the attributes of the request which created the timer
+	logger *slog.Logger
+}
+
+// request returns the request to trigger the pending job
+func (p *pendingTrigger) request(defaults jenkinsTarget) *triggerRequest {
+	return &triggerRequest{target: p.job.target(defaults), job: p.job.Name, params: p.params, repo: p.repo, branch: p.branch, logger: p.logger}
+}
+
+// createTimer schedules the job for the push to repo and branch once its
+// quiet period passed and returns the delay until then, logger carries the
+// attributes of the request the trigger originates from
+func (s *Server) createTimer(logger *slog.Logger, repo, branch string, job jobMapping, params url.Values) time.Duration {
+	s.timeKeeperMu.Lock()
+	defer s.timeKeeperMu.Unlock()
+
+	now := time.Now()
+	var deadline time.Time
+	if s.config.MaxWait > 0 {
+		deadline = now.Add(s.config.MaxWait)
+	}
+
+	key := job.timerKey()
+	if existing, ok := s.timeKeeper[key]; ok {
+		logger.Info("Resetting timer", "event", "timer_reset", "job", job.Name)
+		existing.timer.Stop()
+		delete(s.timeKeeper, key)
+		// keep the deadline of the first request
+		if !existing.deadline.IsZero() {
+			deadline = existing.deadline
+		}
+	}
+
+	quietPeriod := job.quietPeriod(s.config.QuietPeriod)
+
+	// jobs without quiet period are triggered right away
+	if quietPeriod == 0 {
+		logger.Info("Triggering job without quiet period", "event", "job_immediate", "job", job.Name)
+		s.enqueueTrigger(&triggerRequest{target: job.target(s.config.Jenkins), job: job.Name, params: params, repo: repo, branch: branch, logger: logger})
+		return 0
+	}
+	delay := quietPeriod
+	if !deadline.IsZero() && now.Add(delay).After(deadline) {
+		delay = deadline.Sub(now)
+		logger.Debug("Quiet period capped by max wait", "job", job.Name, "delay", delay)
+	}
+
+	logger.Info("Creating timer", "event", "timer_created", "job", job.Name, "quiet_period", quietPeriod)
+
+	pending := &pendingTrigger{repo: repo, branch: branch, job: job, params: params, deadline: deadline, fireAt: now.Add(delay), logger: logger}
+	pending.timer = time.AfterFunc(delay, func() {
+		logger.Info("Quiet period exceeded", "event", "timer_fired", "job", job.Name)
+		s.enqueueTrigger(pending.request(s.config.Jenkins))
+
+		s.timeKeeperMu.Lock()
+		defer s.timeKeeperMu.Unlock()
+
+		// only delete the entry if it still belongs to this timer, a new
+		// request might have registered a fresh one in the meantime
+		if s.timeKeeper[key] == pending {
+			logger.Debug("Deleting timer", "job", job.Name)
+			delete(s.timeKeeper, key)
+		}
+	})
+
+	s.timeKeeper[key] = pending
+	logger.Debug("Timer saved in time keeper", "job", job.Name)
+
+	return delay
+}
+
+// waitForTimers returns once all pending timers fired, or with the signal
+// received on stop before
+func (s *Server) waitForTimers(stop <-chan os.Signal) os.Signal {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		s.timeKeeperMu.Lock()
+		pending := len(s.timeKeeper)
+		s.timeKeeperMu.Unlock()
+
+		if pending == 0 {
+			return nil
+		}
+
+		select {
+		case sig := <-stop:
+			return sig
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushTimers stops all pending timers and triggers their jobs right away.
+// It returns once all jobs are triggered or the context is done.
+func (s *Server) flushTimers(ctx context.Context) error {
+	s.timeKeeperMu.Lock()
+	var flushed []*pendingTrigger
+	for key, pending := range s.timeKeeper {
+		// timers which already fired trigger their job on their own
+		if pending.timer.Stop() {
+			flushed = append(flushed, pending)
+		}
+		delete(s.timeKeeper, key)
+	}
+	s.timeKeeperMu.Unlock()
+
+	log.Printf("Flushing %d pending job(s)\n", len(flushed))
+
+	var triggered []<-chan struct{}
+	for _, pending := range flushed {
+		triggered = append(triggered, s.enqueueTrigger(pending.request(s.config.Jenkins)))
+	}
+
+	for _, done := range triggered {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func ParseGetRequest(r *http.Request) (*WebhookRequest, error) {
+	repo := ""
+	branch := ""
+	files := []string{}
+
+	logger := loggerFrom(r.Context())
+	logger.Debug("Parsing get request")
+	repos, ok := r.URL.Query()["repo"]
+
+	if !ok || len(repos) < 1 {
+		logger.Debug("Repo is missing")
+
+		return nil, errRepoMissing
+	}
+
+	repo = repos[0]
+
+	logger.Debug("Parsed repo", "repo", repo)
+
+	branchs, ok := r.URL.Query()["branch"]
+
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		branch = tagPrefix + tag
+	} else if !ok || len(branchs) < 1 {
+		logger.Debug("Branch is missing, assuming default branch", "branch", DefaultBranch)
+		branch = DefaultBranch
+	} else {
+		branch = branchs[0]
+	}
+
+	logger.Debug("Parsed branch", "branch", branch)
+
+	query := r.URL.Query()
+	changes := fileChanges{Added: query["added"], Modified: query["modified"], Removed: query["removed"]}
+	files = append(files, query["file"]...)
+	for _, list := range [][]string{changes.Added, changes.Modified, changes.Removed} {
+		files = append(files, list...)
+	}
+
+	webhook := newWebhookRequest(r, repo, branch, files)
+	webhook.Changes = changes
+
+	return webhook, nil
+}
+
+// filterJobsByFiles returns the jobs with any file pattern matching any of
+// the changed files of their change types, jobs without file patterns only
+// need a change of their change types
+func filterJobsByFiles(logger *slog.Logger, jobs []jobMapping, webhook *WebhookRequest) []jobMapping {
+	var matched []jobMapping
+
+	for _, job := range jobs {
+		if job.matchesChanges(webhook) {
+			matched = append(matched, job)
+		} else {
+			logger.Debug("No changed file matches the file patterns", "job", job.Name, "rules", len(job.Rules))
+		}
+	}
+
+	return matched
+}
+
+// requestParams returns the parameters of the request which can be passed
+// to parameterized jobs, the query parameter names are upper cased. BRANCH
+// holds the pushed branch, TAG the tag of tag pushes.
+func requestParams(r *http.Request, branch string) url.Values {
+	params := url.Values{}
+
+	for name, values := range r.URL.Query() {
+		if name == "repo" || name == "branch" || name == "tag" || name == "file" || name == "token" || name == "sync" {
+			continue
+		}
+		params[strings.ToUpper(name)] = values
+	}
+
+	if tag, ok := strings.CutPrefix(branch, tagPrefix); ok {
+		params.Set("TAG", tag)
+	} else {
+		params.Set("BRANCH", branch)
+	}
+
+	return params
+}
+
+// clientIP returns the address of the sender of the request. With
+// TrustProxy the address reported by the proxy in X-Forwarded-For or
+// X-Real-IP is used, only the last X-Forwarded-For entry is taken as the
+// ones before it are set by the sender.
+func clientIP(r *http.Request) string {
+	if TrustProxy {
+		forwarded := r.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			entries := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// requestIDFor returns the X-Request-ID of the request or a new random id if
+// the header is missing
+func requestIDFor(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		if len(id) > maxRequestIDLength {
+			id = id[:maxRequestIDLength]
+		}
+		return id
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// triggerResponse summarizes the jobs scheduled by a request to /trigger
+type triggerResponse struct {
+	Key  string             `json:"key"`
+	Jobs []scheduledJobJSON `json:"jobs"`
+}
+
+// scheduledJobJSON is a job scheduled by a request to /trigger
+type scheduledJobJSON struct {
+	Name    string `json:"name"`
+	Jenkins string `json:"jenkins,omitempty"`
+	// QuietPeriod is the quiet period of the job in seconds
+	QuietPeriod float64 `json:"quiet_period_seconds"`
+	// Delay is the time until the job is triggered in seconds, it is
+	// shorter than the quiet period if capped by MaxWait
+	Delay float64 `json:"delay_seconds"`
+}
+
+func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
+	requestID := requestIDFor(r)
+	w.Header().Set("X-Request-ID", requestID)
+	logger := slog.With("request_id", requestID)
+	r = r.WithContext(withLogger(r.Context(), logger))
+
+	logger.Info("Handling new request", "event", "request_received", "client_ip", clientIP(r))
+
+	if !slices.Contains(allowedMethods, r.Method) {
+		logger.Info("Method not allowed, aborting request handling", "event", "method_not_allowed", "method", r.Method)
+		w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.config.IncomingToken != "" && !hasIncomingToken(r, s.config.IncomingToken) {
+		logger.Warn("Token is missing or invalid, aborting request handling", "event", "request_unauthorized")
+		http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	if s.config.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxBodyBytes)
+	}
+
+	// only github sends a signature, other senders authenticate with the
+	// incoming token
+	if s.config.WebhookSecret != "" && r.Method == http.MethodPost && r.Header.Get("X-GitHub-Event") != "" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Warn("Reading request body failed", "error", err)
+			webhookParseErrors.inc(parseErrorReason(err))
+			if isBodyTooLarge(err) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "reading request body failed", http.StatusBadRequest)
+			return
+		}
+
+		if !verifySignature(body, r.Header.Get("X-Hub-Signature-256"), s.config.WebhookSecret) {
+			logger.Warn("Signature is missing or invalid, aborting request handling", "event", "request_unauthorized")
+			webhookParseErrors.inc("bad_signature")
+			http.Error(w, "missing or invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	var webhook *WebhookRequest
+	var err error
+
+	// gitea sends the github event header as well, check its own first
+	switch {
+	case r.Header.Get("X-Gitea-Event") == "push":
+		webhook, err = ParseGiteaWebhook(r)
+	case r.Header.Get("X-GitHub-Event") == "push":
+		webhook, err = ParseGitHubWebhook(r)
+	case r.Header.Get("X-Gitlab-Event") == "Push Hook", r.Header.Get("X-Gitlab-Event") == "Tag Push Hook":
+		webhook, err = ParseGitLabWebhook(r)
+	case r.Header.Get("X-Event-Key") == "repo:refs_changed":
+		webhook, err = ParseBitbucketWebhook(r)
+	default:
+		webhook, err = ParseGetRequest(r)
+	}
+
+	if errors.Is(err, errBranchDeleted) {
+		logger.Info("Branch deleted, skipping", "event", "branch_deleted", "repo", webhook.Repo, "branch", webhook.ref())
+		fmt.Fprintf(w, "branch %s deleted, skipping\n", webhook.ref())
+		return
+	}
+
+	if err != nil {
+		logger.Warn("Invalid request, aborting request handling", "event", "request_invalid", "error", err)
+		webhookParseErrors.inc(parseErrorReason(err))
+		if isBodyTooLarge(err) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	// accepted is set once the jobs of the request are triggered, the
+	// delivery of a request rejected before is handled again when redelivered
+	var accepted bool
+	if id := deliveryID(r); id != "" && s.deliveries != nil {
+		if s.deliveries.duplicate(id) {
+			logger.Info("Duplicate delivery, skipping", "event", "duplicate_delivery", "delivery", id)
+			fmt.Fprintln(w, "duplicate delivery, ignored")
+			return
+		}
+		defer func() {
+			if !accepted {
+				s.deliveries.forget(id)
+			}
+		}()
+	}
+
+	if s.config.NormalizeRepo {
+		webhook.Repo = normalizeRepo(webhook.Repo)
+	}
+
+	repo, branch, files := webhook.Repo, webhook.ref(), webhook.Files
+
+	logger.Info("Request parsed", "event", "request_parsed", "repo", repo, "branch", branch)
+
+	if len(s.config.AllowedRepos) > 0 && !s.config.AllowedRepos[normalizeCase(repo)] {
+		logger.Warn("Repo is not allowed, aborting request handling", "event", "repo_forbidden", "repo", repo)
+		http.Error(w, "repo "+repo+" is not allowed", http.StatusForbidden)
+		return
+	}
+
+	if s.limiter != nil {
+		if ok, wait := s.limiter.allow(repo); !ok {
+			logger.Warn("Repo exceeded its rate limit, aborting request handling", "event", "rate_limited", "repo", repo)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded for "+repo, http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	logger.Debug("Changed files", "files", files)
+
+	key := BuildMappingKey([]string{repo, branch})
+
+	logger.Debug("Searching mappings", "key", key)
+
+	jobs := s.lookupJobs(logger, repo, branch, webhook.DefaultBranch)
+	mappedRepo := repo
+
+	if len(jobs) == 0 && s.config.CatchAll {
+		logger.Debug("No mappings found, using catch-all mappings", "repo", repo)
+		jobs = s.lookupJobs(logger, catchAllRepo, branch, webhook.DefaultBranch)
+		mappedRepo = catchAllRepo
+	}
+
+	mapped := len(jobs)
+	// only mapped repos are counted, any sender could add labels otherwise
+	if mapped > 0 {
+		webhooksReceived.inc(mappedRepo)
+	}
+	jobs = filterJobsByFiles(logger, jobs, webhook)
+
+	if len(jobs) == 0 {
+		available := s.mappingKeysForRepo(repo)
+		logger.Info("No mappings found, aborting request handling", "event", "no_mapping",
+			"repo", repo, "branch", branch, "key", key)
+		logger.Debug("Available mappings for repo", "repo", repo, "keys", available, "mapped_jobs", mapped)
+
+		if !s.config.DebugResponses {
+			http.Error(w, "no mappings found for "+key, http.StatusNotFound)
+			return
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "no mappings found for %s\n", key)
+		fmt.Fprintf(&sb, "available keys for %s: %s\n", repo, strings.Join(available, ", "))
+		if mapped > 0 {
+			fmt.Fprintf(&sb, "%d job(s) skipped, no changed file matches their file pattern\n", mapped)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, sb.String())
+		return
+	}
+
+	accepted = true
+
+	logger.Debug("Mappings found", "jobs", len(jobs))
+
+	if isSyncRequest(r) {
+		s.handleSync(w, r, logger, key, repo, branch, jobs, webhook.Params)
+		s.handledOnce.Do(func() { close(s.handled) })
+		return
+	}
+
+	resp := triggerResponse{Key: key, Jobs: make([]scheduledJobJSON, 0, len(jobs))}
+
+	logger.Debug("Start processing mappings")
+	for _, job := range jobs {
+		delay := s.createTimer(logger, repo, branch, job, job.buildParams(webhook.Params))
+		scheduled := scheduledJobJSON{Name: job.Name, QuietPeriod: job.quietPeriod(s.config.QuietPeriod).Seconds(), Delay: delay.Seconds()}
+		if job.Target != nil {
+			scheduled.Jenkins = job.Target.URL
+		}
+		resp.Jobs = append(resp.Jobs, scheduled)
+	}
+	logger.Debug("End processing mappings")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	logger.Info("Handling request finished", "event", "request_handled",
+		"repo", repo, "branch", branch, "jobs", len(jobs))
+
+	s.handledOnce.Do(func() { close(s.handled) })
+}
+
+// hasBearerToken reports whether the request carries the token as bearer
+// token in its Authorization header
+func hasBearerToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// isBodyTooLarge reports whether err was caused by a request body exceeding
+// the MaxBodyBytes of the config
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+
+	return errors.As(err, &maxBytesErr)
+}
+
+// hasIncomingToken reports whether the request carries the incoming token as
+// bearer token or token query parameter
+func hasIncomingToken(r *http.Request, incoming string) bool {
+	if hasBearerToken(r, incoming) {
+		return true
+	}
+
+	token := r.URL.Query().Get("token")
+
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(incoming)) == 1
+}
+
+// triggerNowHandler triggers the job given by the job query parameter on
+// the global jenkins right away and reports the status code of jenkins
+func (s *Server) triggerNowHandler(w http.ResponseWriter, r *http.Request) {
+	if TriggerNowToken == "" {
+		http.Error(w, "trigger-now is disabled", http.StatusForbidden)
+		return
+	}
+
+	if !hasBearerToken(r, TriggerNowToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job := r.URL.Query().Get("job")
+	if job == "" {
+		http.Error(w, "job is missing", http.StatusBadRequest)
+		return
+	}
+
+	target := s.config.Jenkins
+	slog.Info("Triggering job manually", "event", "job_trigger_now", "job", job)
+
+	status, location, err := s.trigger(r.Context(), target, job, nil, "")
+	if err == nil && TrackBuilds && isQueueItem(status, location) {
+		go s.trackBuild(slog.Default(), target, job, location)
+	}
+	if err != nil {
+		logTriggerError(slog.Default(), job, err)
+
+		var serr *statusError
+		if !errors.As(err, &serr) {
+			http.Error(w, "triggering "+job+" failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	fmt.Fprintf(w, "jenkins responded with status code %d for %s\n", status, triggerURL(target, job, nil))
+}
+
+// healthzHandler reports ready once a mapping file has been loaded
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	s.mappingMu.RLock()
+	loaded := s.mappingLoaded
+	loadErr := s.mappingLoadErr
+	missing := s.mappingMissingCount
+	s.mappingMu.RUnlock()
+
+	if !loaded {
+		http.Error(w, "mapping not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	// a vanished mapping file leaves a stale mapping behind
+	if UnhealthyAfter > 0 && missing >= UnhealthyAfter {
+		http.Error(w, fmt.Sprintf("mapping file missing for %d reload(s): %v", missing, loadErr), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+	if loadErr != nil {
+		fmt.Fprintf(w, "last mapping reload failed: %v\n", loadErr)
+	}
+}
+
+// newRouter returns the handler serving all routes below basePath
+func (s *Server) newRouter(basePath string) http.Handler {
+	// unknown paths are answered with 404 by the mux
+	mux := http.NewServeMux()
+	mux.HandleFunc("/trigger", s.handler)
+	mux.HandleFunc("/healthz", s.healthzHandler)
+	mux.HandleFunc("/metrics", s.metricsHandler)
+	mux.HandleFunc("/mappings", s.mappingsHandler)
+	mux.HandleFunc("/pending", s.pendingHandler)
+	mux.HandleFunc("/trigger-now", s.triggerNowHandler)
+
+	if basePath == "" {
+		return mux
+	}
+
+	return http.StripPrefix(basePath, mux)
+}
+
+// normalizeBasePath returns the base path with a leading and without a
+// trailing slash, the root path is returned as empty path
+func normalizeBasePath(basePath string) (string, error) {
+	if strings.ContainsAny(basePath, "?#") {
+		return "", fmt.Errorf("invalid base path %q", basePath)
+	}
+
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return "", nil
+	}
+
+	return "/" + basePath, nil
+}
+
+func main() {
+	if err := run(os.Args, os.Stdout); err != nil {
+		log.Fatalf("%s\n", err)
+		os.Exit(exitFail)
+	}
+}
+
+func parseFlags(args []string) error {
+	flag.StringVar(&ConfigFile, "config", "", "path to a yaml or toml file setting flags, flags and environment variables take precedence")
+	flag.StringVar(&JenkinsURL, "jenkins-url", "", "sets the jenkins url")
+	flag.StringVar(&JenkinsUser, "jenkins-user", "", "jenkins username")
+	flag.StringVar(&JenkinsToken, "jenkins-token", "", "token for user or root token to trigger anonymously")
+	flag.StringVar(&JenkinsTokenFile, "jenkins-token-file", "", "file to read the jenkins token from, takes precedence over --jenkins-token")
+	flag.StringVar(&JenkinsMulti, "jenkins-multi", "", "root folder or job name")
+	flag.Var(JenkinsHeaders, "jenkins-header", "header sent with every request to jenkins as \"Name: Value\", may be repeated")
+	flag.Int64Var(&MaxBodyBytes, "max-body-bytes", defaultMaxBodyBytes, "maximum size of incoming request bodies in bytes")
+	flag.StringVar(&WebhookSecret, "webhook-secret", "", "secret to verify the X-Hub-Signature-256 header of github webhooks")
+	flag.StringVar(&IncomingToken, "incoming-token", "", "token incoming requests have to send as bearer token or token query parameter")
+	flag.StringVar(&HTTPProxy, "http-proxy", "", "proxy for requests to jenkins, defaults to the proxy of HTTP_PROXY, HTTPS_PROXY and NO_PROXY")
+	flag.IntVar(&BreakerThreshold, "breaker-threshold", 0, "open the circuit breaker after this many consecutive failed triggers of a jenkins, disabled if 0")
+	flag.DurationVar(&BreakerCooldown, "breaker-cooldown", 30*time.Second, "time an open circuit breaker waits before probing jenkins again")
+	flag.StringVar(&BreakerMode, "breaker-mode", breakerModeSkip, "what happens to triggers while the circuit breaker is open, skip or queue")
+	flag.Float64Var(&RateLimit, "rate-limit", 0, "requests per second allowed per repo, unlimited if 0")
+	flag.IntVar(&RateLimitBurst, "rate-limit-burst", 10, "number of requests a repo may send at once before it is rate limited")
+	flag.BoolVar(&Sync, "sync", false, "trigger jobs right away and answer requests with the results of their builds once they finished")
+	flag.DurationVar(&SyncTimeout, "sync-timeout", 30*time.Minute, "maximum time a synchronous request waits for its builds")
+	flag.DurationVar(&DedupeWindow, "dedupe-window", time.Minute, "time redeliveries of a webhook with the same delivery id are ignored, disabled if 0")
+	flag.BoolVar(&TrustProxy, "trust-proxy", false, "log the client ip reported by a load balancer in X-Forwarded-For or X-Real-IP")
+	flag.StringVar(&AllowedMethods, "allowed-methods", "GET,POST", "comma separated list of http methods accepted by /trigger")
+	flag.StringVar(&AllowedRepos, "allowed-repos", "", "comma separated list of repos allowed to trigger jobs, all repos are allowed if empty")
+	flag.StringVar(&BasePath, "base-path", "", "path prefix all routes are served under, e.g. /trigger-proxy")
+	flag.StringVar(&MappingFile, "mappingfile", "mapping.csv", "path to the mapping file, a comma separated list of paths or glob patterns merges several files")
+	flag.StringVar(&TriggerNowToken, "trigger-now-token", "", "bearer token required to access /trigger-now, the endpoint is disabled if empty")
+	flag.StringVar(&MappingsToken, "mappings-token", "", "bearer token required to access /mappings, no token is required if empty")
+	flag.StringVar(&CSVDelimiter, "csv-delimiter", ";", "field delimiter of csv mapping files, a single character")
+	flag.BoolVar(&AllowEmptyMapping, "allow-empty-mapping", false, "start with an empty mapping if the mapping file does not exist yet")
+	flag.BoolVar(&WatchMapping, "watch-mapping", false, "reload the mapping file automatically when it changes")
+	flag.IntVar(&UnhealthyAfter, "unhealthy-after", 0, "report /healthz unhealthy once this many consecutive reloads found the mapping file missing, never if 0")
+	flag.StringVar(&Cause, "cause", "", "build cause passed to jenkins, {repo} and {branch} are replaced by the push")
+	flag.StringVar(&BuildPathTemplate, "build-path-template", defaultBuildPathTemplate,
+		"path appended to the jenkins url to trigger a job, {jobpath}, {job} and {action} are replaced")
+	QuietPeriod = 10 * time.Second
+	flag.Var((*quietPeriodFlag)(&QuietPeriod), "quiet-period", "time trigger-proxy waits for further pushes until the job is triggered, e.g. 10s or 2m, plain numbers are seconds")
+	flag.Var((*quietPeriodFlag)(&QuietPeriod), "quietperiod", "deprecated, use --quiet-period")
+	flag.DurationVar(&MaxWait, "max-wait", 0, "maximum time a job is delayed by repeated requests after the first one, unlimited if 0")
+	flag.DurationVar(&RequestTimeout, "request-timeout", 5*time.Second, "timeout for the trigger request to jenkins, e.g. 15s or 1m")
+	flag.BoolVar(&InsecureSkipVerify, "insecure-skip-verify", false, "skip the verification of the jenkins tls certificate")
+	flag.StringVar(&TLSCert, "tls-cert", "", "path to a pem encoded certificate to serve https with, requires --tls-key")
+	flag.StringVar(&TLSKey, "tls-key", "", "path to the pem encoded private key of --tls-cert")
+	flag.StringVar(&CACert, "ca-cert", "", "path to a pem encoded ca bundle used to verify the jenkins tls certificate")
+	flag.IntVar(&MaxConcurrency, "max-concurrency", defaultMaxConcurrency, "maximum number of concurrent trigger requests to jenkins")
+	flag.IntVar(&MaxRetries, "max-retries", 3, "number of retries for a failed trigger request")
+	flag.DurationVar(&MaxRetryAfter, "max-retry-after", time.Minute, "maximum delay of a retry requested by the Retry-After header of a 503 from jenkins")
+	flag.BoolVar(&UseCrumb, "use-crumb", false, "fetch a csrf crumb from jenkins before triggering jobs")
+	flag.BoolVar(&DebugResponses, "debug-responses", false, "list the available mapping keys of the repo in responses without mapping")
+	flag.BoolVar(&DryRun, "dry-run", false, "log the jobs which would be triggered without calling jenkins")
+	flag.BoolVar(&FlushOnShutdown, "flush-on-shutdown", false, "trigger pending jobs immediately on shutdown")
+	flag.DurationVar(&GracePeriod, "grace-period", 10*time.Second, "time to wait for open requests and flushed jobs on shutdown")
+	flag.DurationVar(&ShutdownTimeout, "shutdown-timeout", 10*time.Second, "time to wait for in-flight trigger requests on shutdown before they are cancelled")
+	flag.StringVar(&LogFormat, "log-format", "text", "log format, text or json")
+	flag.StringVar(&LogLevel, "log-level", "info", "log level, debug, info, warn or error")
+	flag.BoolVar(&Verbose, "verbose", false, "shortcut for --log-level=debug")
+	flag.BoolVar(&Quiet, "quiet", false, "shortcut for --log-level=warn")
+	flag.BoolVar(&Once, "once", false, "exit after the first request scheduling jobs once its jobs are triggered")
+	flag.BoolVar(&TrackBuilds, "track-builds", false, "poll the jenkins queue and log the build number of triggered jobs")
+	flag.BoolVar(&ValidateJobs, "validate-jobs", false, "check at startup that every mapped job exists in jenkins and warn about missing ones")
+	flag.BoolVar(&Strict, "strict", false, "fail startup if --validate-jobs finds missing jobs")
+	flag.BoolVar(&ValidateOnly, "validate-only", false, "validate the mapping file and exit")
+	flag.BoolVar(&PrintConfig, "print-config", false, "log the effective configuration at startup, secrets are redacted")
+	flag.BoolVar(&CatchAll, "catch-all", false, "trigger the jobs mapped to repo * for repos without mapping")
+	flag.StringVar(&DefaultBranch, "default-branch", "master", "branch assumed for requests without branch")
+	flag.BoolVar(&NormalizeRepo, "normalize-repo", false, "reduce repo urls like git@host:org/repo.git to org/repo before matching")
+	flag.BoolVar(&CaseInsensitive, "case-insensitive", false, "match repos and branches case insensitive")
+	flag.BoolVar(&FileMatching, "filematch", false, "only trigger jobs whose file pattern matches a changed file")
+
+	if err := flag.CommandLine.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if err := applyEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		return err
+	}
+
+	if ConfigFile == "" {
+		return nil
+	}
+
+	values, err := loadConfigFile(ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	return applyConfig(flag.CommandLine, values)
+}
+
+func run(args []string, stdout io.Writer) error {
+	log.Println("Starting trigger-proxy ...")
+
+	log.Println("Checking environment variables")
+
+	if err := parseFlags(args); err != nil {
+		return err
+	}
+
+	if Verbose {
+		LogLevel = "debug"
+	} else if Quiet {
+		LogLevel = "warn"
+	}
+
+	if err := setupLogging(LogFormat, LogLevel, os.Stderr); err != nil {
+		return err
+	}
+
+	if PrintConfig {
+		slog.Info("Effective configuration", append([]any{"event", "effective_config"}, effectiveConfig(flag.CommandLine)...)...)
+	}
 
-	req, err := http.NewRequest("POST", url, nil)
+	delimiter, err := parseCSVDelimiter(CSVDelimiter)
 	if err != nil {
-		return false
+		return err
 	}
+	csvDelimiter = delimiter
 
-	// if user and token is defined, use it for basic auth
-	if JenkinsUser != "" {
-		req.SetBasicAuth(JenkinsUser, JenkinsToken)
-	} else {
-		// otherwise use the token for the direct build trigger
-		url = string(url + "?token=" + JenkinsToken)
+	if ValidateOnly {
+		if _, err := readMappingFiles(MappingFile, FileMatching); err != nil {
+			return fmt.Errorf("mapping file %s is invalid:\n%v", MappingFile, err)
+		}
+		log.Printf("Mapping file %s is valid\n", MappingFile)
+
+		return nil
+	}
+
+	if JenkinsURL == "" {
+		return errors.New("No JENKINS_URL defined")
+	}
+
+	if JenkinsUser == "" {
+		log.Println("No JENKINS_USER defined")
 	}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	token := JenkinsToken
+	if JenkinsTokenFile != "" {
+		var err error
+		if token, err = readTokenFile(JenkinsTokenFile); err != nil {
+			return err
+		}
 	}
 
-	timeout := time.Duration(5 * time.Second)
-	client := &http.Client{Transport: tr, Timeout: timeout}
-	resp, err := client.Do(req)
+	if token == "" {
+		return errors.New("No JENKINS_TOKEN defined")
+	}
+
+	jenkins := jenkinsTarget{URL: JenkinsURL, RootURL: JenkinsURL, User: JenkinsUser, Token: token}
+
+	if JenkinsMulti != "" {
+		log.Printf("Found multibranch project: %s\n", JenkinsMulti)
+
+		jenkins.URL = multibranchURL(JenkinsURL, JenkinsMulti)
+	}
 
+	log.Printf("Found configured quiet period: %v\n", QuietPeriod)
+
+	if QuietPeriod < 0 {
+		return errors.New("quiet period must not be negative")
+	}
+
+	if MaxWait < 0 {
+		return errors.New("max wait must not be negative")
+	}
+
+	basePath, err := normalizeBasePath(BasePath)
 	if err != nil {
-		log.Print("Error:", err)
+		return err
+	}
+	if basePath != "" {
+		log.Printf("Found configured base path: %s\n", basePath)
+	}
 
-		return false
+	if UnhealthyAfter < 0 {
+		return errors.New("unhealthy after must not be negative")
 	}
 
-	if !(200 <= resp.StatusCode && resp.StatusCode <= 299) {
-		log.Printf("... %v failed with status code %v\n", job, resp.StatusCode)
-	} else {
-		log.Printf("... %v triggered\n", job)
+	if MaxWait > 0 {
+		log.Printf("Found configured max wait: %v\n", MaxWait)
 	}
 
-	return true
-}
+	if RequestTimeout <= 0 {
+		return errors.New("request timeout must be positive")
+	}
 
-func createJobURL(jenkinsURL, job string) string {
-	return string(jenkinsURL + "/job/" + job + "/build")
-}
+	log.Printf("Found configured request timeout: %v\n", RequestTimeout)
+
+	if MaxRetries < 0 {
+		return errors.New("max retries must not be negative")
+	}
+
+	if MaxRetryAfter < 0 {
+		return errors.New("max retry after must not be negative")
+	}
+
+	if err := validateBuildPathTemplate(BuildPathTemplate); err != nil {
+		return err
+	}
+
+	if BuildPathTemplate != defaultBuildPathTemplate {
+		log.Printf("Found configured build path template: %s\n", BuildPathTemplate)
+	}
+
+	if MaxConcurrency < 1 {
+		return errors.New("max concurrency must be positive")
+	}
 
-func createTimer(job string) {
-	if _, ok := timeKeeper[job]; ok {
-		log.Print("Reseting timer for job ", job)
-		timeKeeper[job].Stop()
-		delete(timeKeeper, job)
+	if DryRun {
+		log.Println("Dry run enabled, no jobs will be triggered")
 	}
 
-	log.Printf("Creating timer for job '%s' with quiet period of %d seconds", job, QuietPeriod)
+	if InsecureSkipVerify {
+		log.Println("TLS certificate verification is disabled")
+	}
+
+	if CACert != "" {
+		log.Printf("Found configured ca bundle: %s\n", CACert)
+
+		pool, err := loadCACert(CACert)
+		if err != nil {
+			return err
+		}
+		rootCAs = pool
+	}
+	log.Printf("Project URL: %s\n", jenkins.URL)
+
+	if HTTPProxy != "" {
+		proxy, err := parseHTTPProxy(HTTPProxy)
+		if err != nil {
+			return err
+		}
+		log.Printf("Found configured http proxy: %s\n", proxy.Redacted())
+		httpProxyURL = proxy
+	}
+
+	tlsConfig, err := serverTLSConfig(TLSCert, TLSKey)
+	if err != nil {
+		return err
+	}
+
+	if SyncTimeout <= 0 {
+		return errors.New("sync timeout must be positive")
+	}
+
+	if DedupeWindow < 0 {
+		return errors.New("dedupe window must not be negative")
+	}
 
-	timer := time.AfterFunc(time.Second*time.Duration(QuietPeriod), func() {
-		log.Print("Quiet period exceeded for job ", job)
-		triggerJob(job)
-		if _, ok := timeKeeper[job]; ok {
-			log.Print("Deleting timer for job ", job)
-			delete(timeKeeper, job)
+	if RateLimit < 0 || RateLimitBurst < 1 {
+		return errors.New("rate limit must not be negative and the burst must be positive")
+	}
+
+	if RateLimit > 0 {
+		log.Printf("Found configured rate limit: %v requests per second, burst %d\n", RateLimit, RateLimitBurst)
+	}
+
+	if BreakerThreshold < 0 {
+		return errors.New("breaker threshold must not be negative")
+	}
+
+	if BreakerThreshold > 0 {
+		if BreakerCooldown <= 0 {
+			return errors.New("breaker cooldown must be positive")
 		}
+		if BreakerMode != breakerModeSkip && BreakerMode != breakerModeQueue {
+			return fmt.Errorf("invalid breaker mode %q, must be %s or %s", BreakerMode, breakerModeSkip, breakerModeQueue)
+		}
+		log.Printf("Found configured circuit breaker: %d failures, cooldown %v, %s triggers while open\n",
+			BreakerThreshold, BreakerCooldown, BreakerMode)
+	}
+
+	methods, err := parseAllowedMethods(AllowedMethods)
+	if err != nil {
+		return err
+	}
+	allowedMethods = methods
+
+	allowedRepos := parseAllowedRepos(AllowedRepos)
+	if len(allowedRepos) > 0 {
+		log.Printf("Found %d allowed repos\n", len(allowedRepos))
+	}
+
+	log.Printf("Found configured mapping file: %s\n", MappingFile)
+
+	s := NewServer(Config{
+		MappingFile:      MappingFile,
+		FileMatching:     FileMatching,
+		WatchMapping:     WatchMapping,
+		Once:             Once,
+		Jenkins:          jenkins,
+		QuietPeriod:      QuietPeriod,
+		MaxWait:          MaxWait,
+		Workers:          MaxConcurrency,
+		AllowedRepos:     allowedRepos,
+		RateLimit:        RateLimit,
+		RateLimitBurst:   RateLimitBurst,
+		BreakerThreshold: BreakerThreshold,
+		BreakerCooldown:  BreakerCooldown,
+		BreakerQueue:     BreakerMode == breakerModeQueue,
+		DedupeWindow:     DedupeWindow,
+		IncomingToken:    IncomingToken,
+		WebhookSecret:    WebhookSecret,
+		MaxBodyBytes:     MaxBodyBytes,
+		DebugResponses:   DebugResponses,
+		CatchAll:         CatchAll,
+		NormalizeRepo:    NormalizeRepo,
 	})
 
-	timeKeeper[job] = timer
-	if _, ok := timeKeeper[job]; ok {
-		log.Print("Timer saved in time keeper")
+	if err := s.ProcessMappingFile(s.config.MappingFile); err != nil {
+		if !AllowEmptyMapping || !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		log.Printf("%v, starting with an empty mapping\n", err)
+		s.setEmptyMapping()
+	}
+
+	if ValidateJobs {
+		missing := s.validateJobs()
+		if len(missing) > 0 && Strict {
+			return fmt.Errorf("mapped jobs missing in jenkins: %s", strings.Join(missing, ", "))
+		}
 	}
 
-	return
+	return s.Serve(basePath, tlsConfig)
 }
 
-func ParseGetRequest(r *http.Request) (string, string, []string, error) {
-	repo := ""
-	branch := ""
-	files := []string{}
+// readTokenFile returns the token stored in the file without the trailing
+// newline
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading token file: %v", err)
+	}
 
-	log.Print("parsing get request")
-	repos, ok := r.URL.Query()["repo"]
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
 
-	if !ok || len(repos) < 1 {
-		log.Print("Repo is missing")
-		log.Print("Aborting request handling")
+// parseCSVDelimiter returns the delimiter rune, it has to be a single
+// character which can separate csv fields
+func parseCSVDelimiter(delimiter string) (rune, error) {
+	if utf8.RuneCountInString(delimiter) != 1 {
+		return 0, fmt.Errorf("csv delimiter %q must be a single character", delimiter)
+	}
 
-		return repo, branch, files, errors.New("repo is missing")
+	r, _ := utf8.DecodeRuneInString(delimiter)
+	if r == '#' || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("csv delimiter %q is not allowed", delimiter)
 	}
 
-	repo = repos[0]
+	return r, nil
+}
 
-	log.Print("Parsed repo:", repo)
+// parseAllowedRepos returns the set of repos of the comma separated list,
+// they are lower cased with CaseInsensitive
+func parseAllowedRepos(list string) map[string]bool {
+	repos := make(map[string]bool)
+	for _, repo := range strings.Split(list, ",") {
+		if repo = strings.TrimSpace(repo); repo != "" {
+			repos[normalizeCase(repo)] = true
+		}
+	}
 
-	branchs, ok := r.URL.Query()["branch"]
+	return repos
+}
 
-	if !ok || len(branchs) < 1 {
-		log.Print("Branch is missing. Assuming master")
-		branch = "master"
-	} else {
-		branch = branchs[0]
+// parseAllowedMethods parses the comma separated list of http methods
+func parseAllowedMethods(list string) ([]string, error) {
+	var methods []string
+	for _, method := range strings.Split(list, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" || slices.Contains(methods, method) {
+			continue
+		}
+		if !isHeaderName(method) {
+			return nil, fmt.Errorf("invalid http method %q", method)
+		}
+		methods = append(methods, method)
 	}
 
-	log.Print("Parsed branch: ", branch)
+	if len(methods) == 0 {
+		return nil, errors.New("no allowed http method defined")
+	}
 
-	return repo, branch, files, nil
+	return methods, nil
 }
 
-func handler(w http.ResponseWriter, r *http.Request) {
-	log.Print("Handling new request")
+// serverTLSConfig returns the tls config serving the certificate and key,
+// it returns nil if neither is given
+func serverTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
 
-	repo, branch, files, err := ParseGetRequest(r)
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("--tls-cert and --tls-key have to be given together")
+	}
 
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
-		log.Print("Aborting request handling")
+		return nil, fmt.Errorf("loading tls certificate %s: %v", certFile, err)
+	}
 
-		return
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// loadCACert returns the system cert pool extended by the certificates in the
+// pem file at given path
+func loadCACert(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Print("Files: ", files)
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
 
-	key := BuildMappingKey([]string{repo, branch})
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + path)
+	}
 
-	log.Print("Searching mappings for key: ", key)
+	return pool, nil
+}
 
-	if len(mapping[key]) == 0 {
-		log.Print("No mappings found")
-		log.Print("Aborting request handling")
-		return
+// readMappingFiles reads the mapping files given by mappingfiles, a comma
+// separated list of paths or glob patterns, and merges their mappings. The
+// file patterns of the jobs are only kept with filematch.
+func readMappingFiles(mappingfiles string, filematch bool) (triggerMapping, error) {
+	paths, err := mappingFilePaths(mappingfiles)
+	if err != nil {
+		return triggerMapping{}, err
 	}
 
-	log.Print("Number of mappings found: ", len(mapping[key]))
+	tm := triggerMapping{mapping: make(map[string][]jobMapping)}
+	for _, path := range paths {
+		fileMapping, err := parseMappingFilePath(path, filematch)
+		if err != nil {
+			if len(paths) > 1 {
+				err = fmt.Errorf("mapping file %s: %w", path, err)
+			}
+			return triggerMapping{}, err
+		}
 
-	log.Print("Start processing mappings")
-	for _, job := range mapping[key] {
-		createTimer(job)
+		log.Printf("Read %d job mapping(s) from %s\n", fileMapping.jobCount(), path)
+		tm.merge(fileMapping)
 	}
-	log.Print("End processing mappings")
 
-	log.Print("Handling request finished")
+	return tm, nil
 }
 
-func main() {
-	if err := run(os.Args, os.Stdout); err != nil {
-		log.Fatalf("%s\n", err)
-		os.Exit(exitFail)
+// ProcessMappingFile loads the mapping files given by mappingfiles as the
+// mapping of the server, see readMappingFiles
+func (s *Server) ProcessMappingFile(mappingfiles string) error {
+	tm, err := readMappingFiles(mappingfiles, s.config.FileMatching)
+	if err != nil {
+		s.setMappingLoadErr(err)
+		return err
 	}
+
+	s.mappingMu.Lock()
+	s.mapping = tm.mapping
+	s.branchPatterns = tm.patterns
+	s.mappingLoaded = true
+	s.mappingLoadErr = nil
+	s.mappingMissingCount = 0
+	s.mappingSource = mappingfiles
+	s.mappingLoadedAt = time.Now()
+	s.mappingMu.Unlock()
+
+	s.reconcileTimers(tm)
+
+	return nil
 }
 
-func parseFlags(args []string) {
-	flag.StringVar(&JenkinsURL, "jenkins-url", "", "sets the jenkins url")
-	flag.StringVar(&JenkinsUser, "jenkins-user", "", "jenkins username")
-	flag.StringVar(&JenkinsToken, "jenkins-token", "", "token for user or root token to trigger anonymously")
-	flag.StringVar(&JenkinsMulti, "jenkins-multi", "", "root folder or job name")
-	flag.StringVar(&MappingFile, "mappingfile", "mapping.csv", "path to the mapping file")
-	flag.IntVar(&QuietPeriod, "quietperiod", 10, "defines the time trigger-proxy will wait until the job is triggered")
-	flag.BoolVar(&FileMatching, "filematch", false, "try to match for file names")
+// reconcileTimers cancels the pending timers of jobs which are not part of
+// the mapping anymore
+func (s *Server) reconcileTimers(tm triggerMapping) {
+	referenced := make(map[string]bool)
+	for _, jobs := range tm.mapping {
+		for _, job := range jobs {
+			referenced[job.timerKey()] = true
+		}
+	}
+
+	s.timeKeeperMu.Lock()
+	defer s.timeKeeperMu.Unlock()
+
+	for key, pending := range s.timeKeeper {
+		if referenced[key] {
+			continue
+		}
 
-	flag.Parse()
+		slog.Info("Cancelling timer of job removed from mapping", "event", "timer_cancelled",
+			"job", pending.job.Name, "repo", pending.repo, "branch", pending.branch)
+		pending.timer.Stop()
+		delete(s.timeKeeper, key)
+	}
 }
 
-func run(args []string, stdout io.Writer) error {
-	log.Println("Starting trigger-proxy ...")
+// mappingFilePaths returns the paths of the comma separated list of mapping
+// files, glob patterns are expanded and have to match at least one file
+func mappingFilePaths(mappingfiles string) ([]string, error) {
+	var paths []string
+	for _, pattern := range strings.Split(mappingfiles, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
 
-	log.Println("Checking environment variables")
+		if !strings.ContainsAny(pattern, "*?[") {
+			paths = append(paths, pattern)
+			continue
+		}
 
-	if JenkinsURL == "" {
-		return errors.New("No JENKINS_URL defined")
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping file pattern %q: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, &mappingNotFoundError{path: pattern}
+		}
+		paths = append(paths, matches...)
 	}
 
-	if JenkinsUser == "" {
-		log.Println("No JENKINS_USER defined")
+	if len(paths) == 0 {
+		return nil, errors.New("no mapping file given")
 	}
 
-	if JenkinsToken == "" {
-		return errors.New("No JENKINS_TOKEN defined")
+	return paths, nil
+}
+
+// parseMappingFilePath parses a single mapping file, files ending in .yaml
+// or .yml are read as yaml, all others as csv
+func parseMappingFilePath(path string, filematch bool) (triggerMapping, error) {
+	slog.Debug("Reading mapping from file", "file", path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = &mappingNotFoundError{path: path}
+		}
+		return triggerMapping{}, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseYAMLMappingFile(file, filematch)
+	default:
+		return ParseMappingFile(file, filematch)
 	}
+}
 
-	if JenkinsMulti != "" {
-		log.Printf("Found multibranch project: %s\n", JenkinsMulti)
+// reloadOnSignal reloads the mapping file whenever SIGHUP is received
+func (s *Server) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
 
-		JenkinsURL = JenkinsURL + "/job/" + JenkinsMulti
+	for range signals {
+		log.Println("Received SIGHUP")
+		s.reloadMappingFile()
 	}
+}
 
-	log.Printf("Found configured quiet period: %d\n", QuietPeriod)
-	log.Printf("Project URL: %s\n", JenkinsURL)
+// reloadMappingFile reloads the mapping file of the config, the current
+// mapping is kept if the file can not be read
+func (s *Server) reloadMappingFile() {
+	log.Printf("Reloading mapping file: %s\n", s.config.MappingFile)
 
-	log.Printf("Found configured mapping file: %s\n", MappingFile)
+	if err := s.ProcessMappingFile(s.config.MappingFile); err != nil {
+		slog.Error("Reloading mapping file failed, keeping current mapping", "event", "mapping_reload_failed",
+			"file", s.config.MappingFile, "error", err)
+		return
+	}
 
-	if err := ProcessMappingFile(MappingFile); err != nil {
-		return err
+	s.mappingMu.RLock()
+	keys := len(s.mapping)
+	s.mappingMu.RUnlock()
+
+	slog.Info("Reloaded mapping file", "event", "mapping_reloaded", "file", s.config.MappingFile, "keys", keys)
+}
+
+// mappingFileMissing counts a poll of the watcher which found the mapping
+// file at path missing like a failed reload
+func (s *Server) mappingFileMissing(path string) {
+	s.setMappingLoadErr(&mappingNotFoundError{path: path})
+}
+
+func (s *Server) setMappingLoadErr(err error) {
+	s.mappingMu.Lock()
+	s.mappingLoadErr = err
+	if errors.Is(err, fs.ErrNotExist) {
+		s.mappingMissingCount++
+	} else {
+		s.mappingMissingCount = 0
 	}
+	s.mappingMu.Unlock()
+}
+
+// mappingNotFoundError is returned by readMappingFiles if the mapping file
+// does not exist
+type mappingNotFoundError struct {
+	path string
+}
+
+func (e *mappingNotFoundError) Error() string {
+	return fmt.Sprintf("mapping file '%s' not found; create it or pass --mappingfile", e.path)
+}
+
+func (e *mappingNotFoundError) Unwrap() error {
+	return fs.ErrNotExist
+}
+
+// setEmptyMapping marks an empty mapping as loaded, the mapping file is
+// expected to be loaded by a later reload
+func (s *Server) setEmptyMapping() {
+	s.mappingMu.Lock()
+	s.mapping = make(map[string][]jobMapping)
+	s.branchPatterns = make(map[string][]string)
+	s.mappingLoaded = true
+	s.mappingLoadErr = nil
+	s.mappingMu.Unlock()
+}
+
+// mappingEntry is a single mapping as read from a mapping file
+type mappingEntry struct {
+	Repo        string
+	Branch      string
+	Job         string
+	FileMatch   string
+	Params      string
+	QuietPeriod string
+	Jenkins     string
+	Endpoint    string
+	Change      string
+}
 
-	http.HandleFunc("/", handler)
+// validate checks that the entry names a repo and at least one job
+func (e mappingEntry) validate() error {
+	if strings.TrimSpace(e.Repo) == "" {
+		return errors.New("no repo provided")
+	}
 
-	log.Println("Serving on port 8080")
-	http.ListenAndServe(":8080", nil)
+	if strings.Trim(e.Job, ", ") == "" {
+		return errors.New("no job provided")
+	}
 
 	return nil
 }
 
-// ProcessMappingFile processes the file at given path
-func ProcessMappingFile(mappingfile string) error {
-	log.Printf("Reading mapping from file: %s\n", mappingfile)
+func (e mappingEntry) key() string {
+	branch := e.Branch
+	if isAnyBranch(branch) {
+		branch = anyBranch
+	}
+
+	return BuildMappingKey([]string{e.Repo, branch})
+}
+
+// jobMappings returns a job for every name of the comma separated job list
+func (e mappingEntry) jobMappings(filematch bool) ([]jobMapping, error) {
+	var params []string
+	if e.Params != "" {
+		params = strings.Split(e.Params, ",")
+	}
+
+	var quietPeriod *time.Duration
+	if e.QuietPeriod != "" {
+		d, err := parseQuietPeriod(e.QuietPeriod)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid quiet period %q", e.QuietPeriod)
+		}
+		quietPeriod = &d
+	}
+
+	var target *jenkinsTarget
+	if e.Jenkins != "" {
+		var err error
+		if target, err = parseJenkinsTarget(e.Jenkins); err != nil {
+			return nil, fmt.Errorf("invalid jenkins url %q: %v", e.Jenkins, err)
+		}
+	}
+
+	switch e.Endpoint {
+	case "", endpointBuildWithParameters:
+	case endpointBuild:
+		if len(params) > 0 {
+			return nil, fmt.Errorf("parameters require the %s endpoint", endpointBuildWithParameters)
+		}
+	default:
+		return nil, fmt.Errorf("invalid endpoint %q, expected %s or %s", e.Endpoint, endpointBuild, endpointBuildWithParameters)
+	}
 
-	file, err := os.Open(mappingfile)
+	changeTypes, err := parseChangeTypes(e.Change)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
 
-	tm, perr := ParseMappingFile(file, FileMatching)
+	// the patterns are separated by whitespace as regular expressions might
+	// contain commas
+	var patterns []*regexp.Regexp
+	if filematch {
+		for _, expr := range strings.Fields(e.FileMatch) {
+			pattern, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid file pattern %q: %v", expr, err)
+			}
+			patterns = append(patterns, pattern)
+		}
+	}
 
-	if perr != nil {
-		return err
+	var rules []fileRule
+	if len(patterns) > 0 || len(changeTypes) > 0 {
+		rules = []fileRule{{FilePatterns: patterns, ChangeTypes: changeTypes}}
 	}
 
-	mapping = tm.mapping
+	var jobs []jobMapping
+	for _, name := range strings.Split(e.Job, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		jobs = append(jobs, jobMapping{Name: name, Params: params, Rules: rules, QuietPeriod: quietPeriod, Target: target, Endpoint: e.Endpoint})
+	}
 
-	return nil
+	return jobs, nil
 }
 
 // ParseMappingFile parses the given file and returns the mapping
 func ParseMappingFile(file io.Reader, filematch bool) (triggerMapping, error) {
-	var m = make(map[string][]string)
+	tm := triggerMapping{mapping: make(map[string][]jobMapping)}
 
 	reader := csv.NewReader(file)
-	reader.Comma = ';'
+	reader.Comma = csvDelimiter
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
 	lineCount := 0
+	var errs []error
 	for {
 		record, err := reader.Read()
 
 		if err == io.EOF {
 			break
 		} else if err != nil {
+			// parse errors already carry the line number, keep going to
+			// report all of them
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				errs = append(errs, err)
+				continue
+			}
 			return triggerMapping{mapping: nil}, err
 		}
 
-		var key string
-		if filematch {
-			if len(record) != 4 {
-				return triggerMapping{mapping: nil}, errors.New("no file matching information provided in mapping file")
-			}
-			key = BuildMappingKey([]string{record[0], record[1], record[3]})
-		} else {
-			key = BuildMappingKey([]string{record[0], record[1]})
+		line, _ := reader.FieldPos(0)
+
+		// lines holding nothing but whitespace separate sections
+		if len(record) == 0 || len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		if len(record) < 3 {
+			errs = append(errs, fmt.Errorf("line %d: no job provided in mapping file", line))
+			continue
+		}
+
+		if filematch && len(record) < 4 {
+			errs = append(errs, fmt.Errorf("line %d: no file matching information provided in mapping file", line))
+			continue
+		}
+
+		entry := mappingEntry{Repo: record[0], Branch: record[1], Job: record[2]}
+		if len(record) > 3 {
+			entry.FileMatch = record[3]
+		}
+		if len(record) > 4 {
+			entry.Params = record[4]
+		}
+		if len(record) > 5 {
+			entry.QuietPeriod = record[5]
+		}
+		if len(record) > 6 {
+			entry.Jenkins = record[6]
+		}
+		if len(record) > 7 {
+			entry.Endpoint = record[7]
+		}
+		if len(record) > 8 {
+			entry.Change = record[8]
+		}
+
+		if err := entry.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %v", line, err))
+			continue
+		}
+
+		if err := tm.add(entry, filematch); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %v", line, err))
+			continue
 		}
-		m[key] = append(m[key], record[2])
 		lineCount++
 	}
 
-	log.Printf("Successfully read mappings: %d\n", lineCount)
+	if len(errs) > 0 {
+		return triggerMapping{mapping: nil}, errors.Join(errs...)
+	}
+
+	slog.Debug("Successfully read mappings", "mappings", lineCount)
 
-	return triggerMapping{mapping: m}, nil
+	return tm, nil
 }
 
-// BuildMappingKey returns the mapping for a given set of strings
+// keyEscaper escapes the separator of mapping keys within their parts
+var keyEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`)
+
+// BuildMappingKey returns the mapping for a given set of strings, a | within
+// the strings is escaped so that keys of different strings never collide
 func BuildMappingKey(keys []string) string {
-	return strings.Join(keys, "|")
+	escaped := make([]string, len(keys))
+	for i, key := range keys {
+		escaped[i] = keyEscaper.Replace(key)
+	}
+
+	return normalizeCase(strings.Join(escaped, "|"))
+}
+
+// normalizeCase lower cases s if case insensitive matching is enabled
+func normalizeCase(s string) string {
+	if CaseInsensitive {
+		return strings.ToLower(s)
+	}
+
+	return s
 }
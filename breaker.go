@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// breakerProbeWait is the delay between two checks of a queued trigger
+	// while the probe of a half open circuit is running
+	breakerProbeWait = time.Second
+	// breakerModeSkip and breakerModeQueue are the values of BreakerMode
+	breakerModeSkip  = "skip"
+	breakerModeQueue = "queue"
+)
+
+// errCircuitOpen is returned for triggers skipped by an open circuit
+var errCircuitOpen = errors.New("circuit breaker is open, jenkins keeps failing")
+
+// circuitState is the state of the circuit of a jenkins master, its value
+// is exposed in triggerproxy_circuit_breaker_state
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+// circuit is the breaker state of a single jenkins master
+type circuit struct {
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// circuitBreaker opens the circuit of a jenkins master after threshold
+// consecutive failed triggers. While it is open triggers are skipped or,
+// with queue, held back. After the cooldown a single trigger is let
+// through as probe, its result closes or opens the circuit again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	queue     bool
+	circuits  map[string]*circuit
+	now       func() time.Time
+}
+
+// newCircuitBreaker returns a breaker opening after threshold failures for
+// the cooldown
+func newCircuitBreaker(threshold int, cooldown time.Duration, queue bool) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		queue:     queue,
+		circuits:  make(map[string]*circuit),
+		now:       time.Now,
+	}
+}
+
+// breakerKey identifies the jenkins master of the target
+func breakerKey(target jenkinsTarget) string {
+	if target.RootURL != "" {
+		return target.RootURL
+	}
+
+	return target.URL
+}
+
+func (b *circuitBreaker) circuit(key string) *circuit {
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{}
+		b.circuits[key] = c
+	}
+
+	return c
+}
+
+// setState changes the state of the circuit of key and logs the change
+func (b *circuitBreaker) setState(key string, c *circuit, state circuitState) {
+	if c.state == state {
+		return
+	}
+
+	c.state = state
+	circuitBreakerState.set(float64(state), key)
+
+	switch state {
+	case circuitOpen:
+		slog.Warn("Circuit breaker opened", "event", "circuit_opened", "jenkins", key,
+			"failures", c.failures, "cooldown", b.cooldown)
+	case circuitHalfOpen:
+		slog.Info("Circuit breaker half open, probing jenkins", "event", "circuit_half_open", "jenkins", key)
+	default:
+		slog.Info("Circuit breaker closed", "event", "circuit_closed", "jenkins", key)
+	}
+}
+
+// allow reports whether a trigger may be sent to the jenkins of key,
+// otherwise it returns the time until it should be asked again
+func (b *circuitBreaker) allow(key string) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(key)
+	switch c.state {
+	case circuitClosed:
+		return true, 0
+	case circuitOpen:
+		if elapsed := b.now().Sub(c.openedAt); elapsed < b.cooldown {
+			return false, b.cooldown - elapsed
+		}
+		b.setState(key, c, circuitHalfOpen)
+		return true, 0
+	default:
+		// the probe is still running
+		return false, breakerProbeWait
+	}
+}
+
+// record records the result of a trigger sent to the jenkins of key
+func (b *circuitBreaker) record(key string, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(key)
+	if ok {
+		c.failures = 0
+		b.setState(key, c, circuitClosed)
+		return
+	}
+
+	c.failures++
+	// failures of triggers sent before the circuit opened keep its cooldown
+	if c.state == circuitOpen {
+		return
+	}
+	if c.state == circuitHalfOpen || c.failures >= b.threshold {
+		c.openedAt = b.now()
+		b.setState(key, c, circuitOpen)
+	}
+}
+
+// wait returns once a trigger may be sent to the jenkins of key. Without
+// queue it returns errCircuitOpen right away if the circuit is open.
+func (b *circuitBreaker) wait(ctx context.Context, key string) error {
+	for {
+		ok, retryIn := b.allow(key)
+		if ok {
+			return nil
+		}
+		if !b.queue {
+			return errCircuitOpen
+		}
+
+		select {
+		case <-time.After(retryIn):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_circuitBreaker(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(2, time.Minute, false)
+	b.now = func() time.Time { return now }
+	failed, succeeded := false, true
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		key       string
+		record    *bool
+		want      bool
+		wantWait  time.Duration
+		wantState circuitState
+	}{
+		{name: "closed", key: "a", want: true, wantState: circuitClosed},
+		{name: "first failure", key: "a", record: &failed, want: true, wantState: circuitClosed},
+		{name: "threshold reached", key: "a", record: &failed, want: false, wantWait: time.Minute, wantState: circuitOpen},
+		{name: "other jenkins", key: "b", want: true, wantState: circuitClosed},
+		{name: "cooling down", advance: 30 * time.Second, key: "a", want: false, wantWait: 30 * time.Second, wantState: circuitOpen},
+		{name: "probe", advance: 30 * time.Second, key: "a", want: true, wantState: circuitHalfOpen},
+		{name: "probe running", key: "a", want: false, wantWait: breakerProbeWait, wantState: circuitHalfOpen},
+		{name: "probe failed", key: "a", record: &failed, want: false, wantWait: time.Minute, wantState: circuitOpen},
+		{name: "second probe", advance: time.Minute, key: "a", want: true, wantState: circuitHalfOpen},
+		{name: "probe succeeded", key: "a", record: &succeeded, want: true, wantState: circuitClosed},
+		{name: "failures reset", key: "a", record: &failed, want: true, wantState: circuitClosed},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.record != nil {
+			b.record(step.key, *step.record)
+		}
+		got, wait := b.allow(step.key)
+		if got != step.want || wait != step.wantWait {
+			t.Errorf("%s: allow() = %v, %v, want %v, %v", step.name, got, wait, step.want, step.wantWait)
+		}
+		if state := b.circuits[step.key].state; state != step.wantState {
+			t.Errorf("%s: state = %v, want %v", step.name, state, step.wantState)
+		}
+	}
+}
+
+func Test_circuitBreakerWait(t *testing.T) {
+	b := newCircuitBreaker(1, time.Hour, false)
+	b.record("a", false)
+	if err := b.wait(context.Background(), "a"); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("wait() error = %v, want %v", err, errCircuitOpen)
+	}
+
+	b.queue = true
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.wait(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	b.cooldown = 50 * time.Millisecond
+	if err := b.wait(context.Background(), "a"); err != nil {
+		t.Errorf("wait() error = %v, want nil after the cooldown", err)
+	}
+}
+
+func Test_triggerJobCircuitOpen(t *testing.T) {
+	jenkins := newFakeJenkins(t, 1)
+	s := newTestServer(t)
+	s.breaker = newCircuitBreaker(1, time.Hour, false)
+
+	target := jenkinsTarget{URL: jenkins.URL, RootURL: jenkins.URL, User: "user", Token: "secret"}
+	if _, _, err := s.triggerJob(context.Background(), target, "job", nil, ""); err == nil {
+		t.Fatal("triggerJob() error = nil, want the 503 of jenkins")
+	}
+	if _, _, err := s.triggerJob(context.Background(), target, "job", nil, ""); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("triggerJob() error = %v, want %v", err, errCircuitOpen)
+	}
+	if calls := len(jenkins.received()); calls != 1 {
+		t.Errorf("triggerJob() sent %d requests, want 1", calls)
+	}
+}
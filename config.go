@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// envNames holds the environment variables of flags whose name can not be
+// derived from the flag name
+var envNames = map[string]string{
+	"mappingfile": "MAPPING_FILE",
+	"quietperiod": "QUIET_PERIOD",
+	"filematch":   "FILE_MATCHING",
+	// HTTP_PROXY is the proxy of the environment, which honors NO_PROXY
+	"http-proxy": "JENKINS_PROXY",
+}
+
+// secretFlags are the flags whose values are redacted by effectiveConfig
+var secretFlags = map[string]bool{
+	"jenkins-token":     true,
+	"jenkins-header":    true,
+	"webhook-secret":    true,
+	"incoming-token":    true,
+	"mappings-token":    true,
+	"trigger-now-token": true,
+}
+
+// urlFlags are the flags holding urls, their passwords are redacted by
+// effectiveConfig
+var urlFlags = map[string]bool{
+	"jenkins-url": true,
+	"http-proxy":  true,
+}
+
+// flagAliases maps deprecated flag names to the flags replacing them
+var flagAliases = map[string]string{
+	"quietperiod": "quiet-period",
+}
+
+// visitedFlags returns the names of the flags which have been set, a flag
+// counts as set if its alias or the flag it is an alias of has been set
+func visitedFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for alias, name := range flagAliases {
+		if set[alias] || set[name] {
+			set[alias] = true
+			set[name] = true
+		}
+	}
+
+	return set
+}
+
+// envName returns the environment variable used as fallback for the flag
+func envName(flagName string) string {
+	if name, ok := envNames[flagName]; ok {
+		return name
+	}
+
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets every flag which was not given on the command line from its
+// environment variable, if that is set
+func applyEnv(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	set := visitedFlags(fs)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+
+		value, ok := lookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+
+		if serr := fs.Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, envName(f.Name), serr)
+		}
+	})
+
+	return err
+}
+
+// effectiveConfig returns the name and value of every flag as key value
+// pairs for slog, secrets are shown as *** and deprecated aliases are left
+// out
+func effectiveConfig(fs *flag.FlagSet) []any {
+	var attrs []any
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := flagAliases[f.Name]; ok {
+			return
+		}
+
+		value := f.Value.String()
+		switch {
+		case value == "":
+		case secretFlags[f.Name]:
+			value = "***"
+		case urlFlags[f.Name]:
+			if u, err := url.Parse(value); err == nil {
+				value = u.Redacted()
+			}
+		}
+		attrs = append(attrs, f.Name, value)
+	})
+
+	return attrs
+}
+
+// loadConfigFile reads the config file at path, .toml files are read as
+// toml, all others as yaml
+func loadConfigFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	separator := ":"
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		separator = "="
+	}
+
+	values, err := parseConfig(file, separator)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	return values, nil
+}
+
+// parseConfig parses the subset of yaml and toml used by config files: flat
+// key value pairs split by separator with scalar values, comments and blank
+// lines
+func parseConfig(r io.Reader, separator string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(stripYAMLComment(scanner.Text()))
+		if line == "" || line == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", lineNumber)
+		}
+
+		key, value, ok := strings.Cut(line, separator)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key%s value", lineNumber, separator)
+		}
+
+		key = strings.TrimSpace(key)
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNumber, key)
+		}
+
+		value, err := unquoteYAML(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+
+		values[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// applyConfig sets every flag which was neither given on the command line
+// nor by its environment variable from the config values, unknown keys are
+// logged and ignored
+func applyConfig(fs *flag.FlagSet, values map[string]string) error {
+	set := visitedFlags(fs)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if fs.Lookup(key) == nil {
+			log.Printf("Ignoring unknown config key %q\n", key)
+			continue
+		}
+
+		if set[key] {
+			continue
+		}
+
+		if err := fs.Set(key, values[key]); err != nil {
+			return fmt.Errorf("invalid value %q for config key %s: %v", values[key], key, err)
+		}
+	}
+
+	return nil
+}
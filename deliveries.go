@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxDeliveries bounds the number of delivery ids the delivery cache keeps
+const maxDeliveries = 10000
+
+// deliveryHeaders are the headers holding the id of a webhook delivery,
+// redeliveries of a webhook keep its id
+var deliveryHeaders = []string{"X-GitHub-Delivery", "X-Gitea-Delivery", "X-Gitlab-Event-UUID"}
+
+// deliveryID returns the delivery id of the request prefixed with its
+// header, empty if the sender does not send one
+func deliveryID(r *http.Request) string {
+	for _, header := range deliveryHeaders {
+		if id := r.Header.Get(header); id != "" {
+			return header + ":" + id
+		}
+	}
+
+	return ""
+}
+
+// deliveryCache remembers the ids of recent webhook deliveries for the ttl
+// to skip redeliveries
+type deliveryCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+// newDeliveryCache returns a cache remembering deliveries for the ttl
+func newDeliveryCache(ttl time.Duration) *deliveryCache {
+	return &deliveryCache{
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
+
+// duplicate records the delivery id and reports whether it was already
+// seen within the ttl
+func (c *deliveryCache) duplicate(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if seenAt, ok := c.seen[id]; ok && now.Sub(seenAt) < c.ttl {
+		return true
+	}
+
+	if len(c.seen) >= maxDeliveries {
+		c.sweep(now)
+	}
+	c.seen[id] = now
+
+	return false
+}
+
+// forget drops the delivery id, a redelivery is not skipped then
+func (c *deliveryCache) forget(id string) {
+	c.mu.Lock()
+	delete(c.seen, id)
+	c.mu.Unlock()
+}
+
+// sweep drops the expired ids, if the cache is still full afterwards the
+// oldest id is dropped
+func (c *deliveryCache) sweep(now time.Time) {
+	var oldestID string
+	var oldest time.Time
+	for id, seenAt := range c.seen {
+		if now.Sub(seenAt) >= c.ttl {
+			delete(c.seen, id)
+			continue
+		}
+		if oldestID == "" || seenAt.Before(oldest) {
+			oldestID, oldest = id, seenAt
+		}
+	}
+
+	if len(c.seen) >= maxDeliveries {
+		delete(c.seen, oldestID)
+	}
+}
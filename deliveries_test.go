@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_deliveryCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newDeliveryCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		id      string
+		want    bool
+	}{
+		{"first", 0, "a", false},
+		{"redelivery", 10 * time.Second, "a", true},
+		{"other delivery", 0, "b", false},
+		{"expired", time.Minute, "a", false},
+		{"seen again", 0, "a", true},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if got := c.duplicate(step.id); got != step.want {
+			t.Errorf("%s: duplicate() = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func Test_deliveryID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{"github", "X-GitHub-Delivery", "72d3162e", "X-GitHub-Delivery:72d3162e"},
+		{"gitlab", "X-Gitlab-Event-UUID", "13792a34", "X-Gitlab-Event-UUID:13792a34"},
+		{"none", "X-Request-ID", "abc", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", nil)
+			r.Header.Set(tt.header, tt.value)
+			if got := deliveryID(r); got != tt.want {
+				t.Errorf("deliveryID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerDuplicateDelivery(t *testing.T) {
+	s := newTestServer(t)
+	s.mapping = map[string][]jobMapping{"org/repo|main": {{Name: "job1"}}}
+	s.deliveries = newDeliveryCache(time.Minute)
+	s.config.QuietPeriod = 60 * time.Second
+
+	body := `{"ref":"refs/heads/main","repository":{"full_name":"org/repo"}}`
+	for i, duplicate := range []bool{false, true} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("X-GitHub-Event", "push")
+		r.Header.Set("X-GitHub-Delivery", "72d3162e")
+		w := httptest.NewRecorder()
+		s.handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("delivery %d: handler() status = %v, want %v", i+1, w.Code, http.StatusOK)
+		}
+		if got := strings.Contains(w.Body.String(), "duplicate delivery, ignored"); got != duplicate {
+			t.Errorf("delivery %d: handler() body = %q, want duplicate %v", i+1, w.Body.String(), duplicate)
+		}
+	}
+}
+
+func TestHandlerRateLimitedRedelivery(t *testing.T) {
+	s := newTestServer(t)
+	s.mapping = map[string][]jobMapping{"org/repo|main": {{Name: "job1"}}}
+	s.deliveries = newDeliveryCache(time.Minute)
+	s.config.QuietPeriod = 60 * time.Second
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.limiter = newRateLimiter(1, 1)
+	s.limiter.now = func() time.Time { return now }
+
+	body := `{"ref":"refs/heads/main","repository":{"full_name":"org/repo"}}`
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		delivery  string
+		want      int
+		duplicate bool
+	}{
+		{"first", 0, "a", http.StatusOK, false},
+		{"rate limited", 0, "b", http.StatusTooManyRequests, false},
+		{"redelivered", time.Second, "b", http.StatusOK, false},
+		{"duplicate", time.Second, "b", http.StatusOK, true},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("X-GitHub-Event", "push")
+		r.Header.Set("X-GitHub-Delivery", step.delivery)
+		w := httptest.NewRecorder()
+		s.handler(w, r)
+		if w.Code != step.want {
+			t.Errorf("%s: handler() status = %v, want %v", step.name, w.Code, step.want)
+		}
+		if got := strings.Contains(w.Body.String(), "duplicate delivery, ignored"); got != step.duplicate {
+			t.Errorf("%s: handler() body = %q, want duplicate %v", step.name, w.Body.String(), step.duplicate)
+		}
+	}
+}
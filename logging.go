@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// setupLogging configures the default logger for the given format and
+// level. The text format keeps the default logger, which writes through the
+// log package.
+func setupLogging(format, level string, w io.Writer) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+
+	switch format {
+	case "text":
+		slog.SetLogLoggerLevel(l)
+		return nil
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l})))
+		return nil
+	}
+
+	return fmt.Errorf("unknown log format %q", format)
+}
+
+// loggerKey is the context key of the logger of a request
+type loggerKey struct{}
+
+// withLogger returns a copy of ctx carrying the logger
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger carried by ctx, the default logger if it
+// carries none
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
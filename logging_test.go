@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"testing"
+)
+
+func Test_setupLogging(t *testing.T) {
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+	defer slog.SetLogLoggerLevel(slog.LevelInfo)
+	defer log.SetFlags(log.Flags())
+	defer log.SetOutput(log.Writer())
+
+	if err := setupLogging("xml", "info", nil); err == nil {
+		t.Errorf("setupLogging() expected error for unknown format")
+	}
+	if err := setupLogging("text", "chatty", nil); err == nil {
+		t.Errorf("setupLogging() expected error for unknown level")
+	}
+
+	var buf bytes.Buffer
+	if err := setupLogging("json", "info", &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	slog.Debug("Searching mappings", "key", "a|b")
+	slog.Info("Creating timer", "event", "timer_created", "job", "job1")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("setupLogging() no json output: %v", err)
+	}
+	if line["event"] != "timer_created" || line["job"] != "job1" || line["msg"] != "Creating timer" {
+		t.Errorf("setupLogging() unexpected log line %v", line)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// mappingsResponse is the current mapping as served by /mappings
+type mappingsResponse struct {
+	File     string                     `json:"file"`
+	LoadedAt time.Time                  `json:"loaded_at"`
+	Mappings map[string][]mappedJobJSON `json:"mappings"`
+}
+
+// mappedJobJSON is a job of the mapping as served by /mappings
+type mappedJobJSON struct {
+	Name        string           `json:"name"`
+	Params      []string         `json:"params,omitempty"`
+	Rules       []mappedRuleJSON `json:"rules,omitempty"`
+	QuietPeriod float64          `json:"quiet_period_seconds"`
+	Jenkins     string           `json:"jenkins,omitempty"`
+}
+
+// mappedRuleJSON is a rule of a mapped job as served by /mappings
+type mappedRuleJSON struct {
+	FilePatterns []string `json:"file_patterns,omitempty"`
+	ChangeTypes  []string `json:"change_types,omitempty"`
+}
+
+// mappingsHandler serves the current mapping as json, it requires the
+// MappingsToken as bearer token if one is configured
+func (s *Server) mappingsHandler(w http.ResponseWriter, r *http.Request) {
+	if MappingsToken != "" && !hasBearerToken(r, MappingsToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.mappingMu.RLock()
+	resp := mappingsResponse{
+		File:     s.mappingSource,
+		LoadedAt: s.mappingLoadedAt,
+		Mappings: make(map[string][]mappedJobJSON, len(s.mapping)),
+	}
+	for key, jobs := range s.mapping {
+		for _, job := range jobs {
+			mapped := mappedJobJSON{Name: job.Name, Params: job.Params, QuietPeriod: job.quietPeriod(s.config.QuietPeriod).Seconds()}
+			for _, rule := range job.Rules {
+				mapped.Rules = append(mapped.Rules, mappedRuleJSON{FilePatterns: rule.patternStrings(), ChangeTypes: rule.ChangeTypes})
+			}
+			if job.Target != nil {
+				mapped.Jenkins = job.Target.URL
+			}
+			resp.Mappings[key] = append(resp.Mappings[key], mapped)
+		}
+	}
+	s.mappingMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
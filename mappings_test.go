@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_mappingsHandler(t *testing.T) {
+	s := newTestServer(t)
+	loadedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.mapping = map[string][]jobMapping{
+		"git://repo|master": {
+			{Name: "job1"},
+			{Name: "job2", Params: []string{"BRANCH"}, Rules: []fileRule{{FilePatterns: []*regexp.Regexp{regexp.MustCompile("src/")}}}, QuietPeriod: durationPtr(60 * time.Second)},
+		},
+	}
+	s.mappingSource = "mapping.csv"
+	s.mappingLoadedAt = loadedAt
+	s.config.QuietPeriod = 10 * time.Second
+	defer func() {
+		MappingsToken = ""
+	}()
+
+	want := mappingsResponse{
+		File:     "mapping.csv",
+		LoadedAt: loadedAt,
+		Mappings: map[string][]mappedJobJSON{
+			"git://repo|master": {
+				{Name: "job1", QuietPeriod: 10},
+				{Name: "job2", Params: []string{"BRANCH"}, Rules: []mappedRuleJSON{{FilePatterns: []string{"src/"}}}, QuietPeriod: 60},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		auth       string
+		wantStatus int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			MappingsToken = tt.token
+			req := httptest.NewRequest("GET", "/mappings", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			s.mappingsHandler(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("mappingsHandler() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var got mappingsResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("mappingsHandler() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricVec is a labeled counter or gauge rendered in the Prometheus text
+// exposition format
+type metricVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu      sync.Mutex
+	samples map[string]*sample
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+var (
+	jobsTriggered = newMetricVec("triggerproxy_jobs_triggered_total", "counter",
+		"Number of job trigger attempts.", "job", "result")
+	webhooksReceived = newMetricVec("triggerproxy_webhooks_received_total", "counter",
+		"Number of parsed incoming webhooks of mapped repos, repo * for the catch-all mapping.", "repo")
+	webhookParseErrors = newMetricVec("triggerproxy_webhook_parse_errors_total", "counter",
+		"Number of incoming webhooks rejected as unparsable or unsigned.", "reason")
+	circuitBreakerState = newMetricVec("triggerproxy_circuit_breaker_state", "gauge",
+		"State of the circuit breaker per jenkins, 0 closed, 1 half open and 2 open.", "jenkins")
+	activeTimers = newMetricVec("triggerproxy_active_timers", "gauge",
+		"Number of currently pending quiet period timers.")
+	lastTriggered = newMetricVec("triggerproxy_last_trigger_timestamp_seconds", "gauge",
+		"Unix time of the last successful trigger per repo, branch and job.", "repo", "branch", "job")
+)
+
+func newMetricVec(name, kind, help string, labels ...string) *metricVec {
+	return &metricVec{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		samples: make(map[string]*sample),
+	}
+}
+
+func (m *metricVec) sample(labelValues []string) *sample {
+	key := strings.Join(labelValues, "\xff")
+
+	s, ok := m.samples[key]
+	if !ok {
+		s = &sample{labelValues: labelValues}
+		m.samples[key] = s
+	}
+
+	return s
+}
+
+// inc increments the sample with the given label values by one
+func (m *metricVec) inc(labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sample(labelValues).value++
+}
+
+// set sets the sample with the given label values
+func (m *metricVec) set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sample(labelValues).value = value
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	lines := make([]string, 0, len(m.samples))
+	for _, s := range m.samples {
+		pairs := make([]string, len(m.labels))
+		for i, label := range m.labels {
+			pairs[i] = fmt.Sprintf("%s=\"%s\"", label, escapeLabelValue(s.labelValues[i]))
+		}
+
+		labels := ""
+		if len(pairs) > 0 {
+			labels = "{" + strings.Join(pairs, ",") + "}"
+		}
+
+		lines = append(lines, fmt.Sprintf("%s%s %v\n", m.name, labels, s.value))
+	}
+
+	sort.Strings(lines)
+	for _, line := range lines {
+		io.WriteString(w, line)
+	}
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// metricsHandler serves all metrics in the Prometheus text format
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	s.timeKeeperMu.Lock()
+	activeTimers.set(float64(len(s.timeKeeper)))
+	s.timeKeeperMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	for _, m := range []*metricVec{jobsTriggered, webhooksReceived, webhookParseErrors, activeTimers, lastTriggered, circuitBreakerState} {
+		m.write(w)
+	}
+}
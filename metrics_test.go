@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricVecWrite(t *testing.T) {
+	m := newMetricVec("test_total", "counter", "Test counter.", "job", "result")
+	m.inc("job2", "success")
+	m.inc("job1", "failure")
+	m.inc("job1", "failure")
+	m.inc(`a"b`, "success")
+
+	var sb strings.Builder
+	m.write(&sb)
+
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{job="a\"b",result="success"} 1
+test_total{job="job1",result="failure"} 2
+test_total{job="job2",result="success"} 1
+`
+	if got := sb.String(); got != want {
+		t.Errorf("write() = %q, want %q", got, want)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	s := newTestServer(t)
+	w := httptest.NewRecorder()
+	s.metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE triggerproxy_jobs_triggered_total counter",
+		"# TYPE triggerproxy_webhooks_received_total counter",
+		"# TYPE triggerproxy_webhook_parse_errors_total counter",
+		"triggerproxy_active_timers 0\n",
+		"# TYPE triggerproxy_last_trigger_timestamp_seconds gauge",
+		"# TYPE triggerproxy_circuit_breaker_state gauge",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metricsHandler() body does not contain %q", want)
+		}
+	}
+}
+
+func TestHandlerWebhooksReceived(t *testing.T) {
+	s := newTestServer(t)
+	s.mapping = map[string][]jobMapping{
+		"git://metrics-mapped|master": {{Name: "job1"}},
+		"*|master":                    {{Name: "lint"}},
+	}
+	s.config.QuietPeriod = 60 * time.Second
+	s.config.AllowedRepos = parseAllowedRepos("git://metrics-mapped,git://metrics-unmapped,git://metrics-other")
+
+	tests := []struct {
+		name     string
+		repo     string
+		catchAll bool
+		label    string
+		want     bool
+	}{
+		{"mapped", "git://metrics-mapped", false, "git://metrics-mapped", true},
+		{"forbidden", "git://metrics-forbidden", false, "git://metrics-forbidden", false},
+		{"unmapped", "git://metrics-unmapped", false, "git://metrics-unmapped", false},
+		{"catch-all", "git://metrics-other", true, "git://metrics-other", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer stopTimers(s)
+			s.config.CatchAll = tt.catchAll
+			s.handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/?repo="+tt.repo, nil))
+
+			webhooksReceived.mu.Lock()
+			_, got := webhooksReceived.samples[tt.label]
+			webhooksReceived.mu.Unlock()
+			if got != tt.want {
+				t.Errorf("handler() counted %s = %v, want %v", tt.label, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// pendingJobJSON is a job waiting for its quiet period as served by /pending
+type pendingJobJSON struct {
+	Job     string    `json:"job"`
+	Jenkins string    `json:"jenkins,omitempty"`
+	Repo    string    `json:"repo"`
+	Branch  string    `json:"branch"`
+	FiresAt time.Time `json:"fires_at"`
+	// Remaining is the time until the job is triggered in seconds
+	Remaining float64    `json:"remaining_seconds"`
+	Deadline  *time.Time `json:"deadline,omitempty"`
+}
+
+// pendingHandler serves the jobs waiting for their quiet period as json,
+// ordered by the time they fire at. It requires the MappingsToken as bearer
+// token if one is configured.
+func (s *Server) pendingHandler(w http.ResponseWriter, r *http.Request) {
+	if MappingsToken != "" && !hasBearerToken(r, MappingsToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	jobs := []pendingJobJSON{}
+
+	s.timeKeeperMu.Lock()
+	for _, pending := range s.timeKeeper {
+		job := pendingJobJSON{
+			Job:       pending.job.Name,
+			Repo:      pending.repo,
+			Branch:    pending.branch,
+			FiresAt:   pending.fireAt,
+			Remaining: max(pending.fireAt.Sub(now), 0).Seconds(),
+		}
+		if pending.job.Target != nil {
+			job.Jenkins = pending.job.Target.URL
+		}
+		if !pending.deadline.IsZero() {
+			deadline := pending.deadline
+			job.Deadline = &deadline
+		}
+		jobs = append(jobs, job)
+	}
+	s.timeKeeperMu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].FiresAt.Equal(jobs[j].FiresAt) {
+			return jobs[i].FiresAt.Before(jobs[j].FiresAt)
+		}
+		return jobs[i].Job < jobs[j].Job
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_pendingHandler(t *testing.T) {
+	s := newTestServer(t)
+	s.config.QuietPeriod = 60 * time.Second
+	defer func() { MappingsToken = "" }()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s.createTimer(logger, "git://repo", "master", jobMapping{Name: "job1"}, nil)
+	s.createTimer(logger, "git://repo", "devel", jobMapping{Name: "job2", QuietPeriod: durationPtr(30 * time.Second)}, nil)
+
+	tests := []struct {
+		name       string
+		token      string
+		auth       string
+		wantStatus int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			MappingsToken = tt.token
+			req := httptest.NewRequest("GET", "/pending", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			s.pendingHandler(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("pendingHandler() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var got []pendingJobJSON
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("pendingHandler() returned %d jobs, want 2", len(got))
+			}
+			if got[0].Job != "job2" || got[0].Branch != "devel" || got[1].Job != "job1" || got[1].Repo != "git://repo" {
+				t.Errorf("pendingHandler() = %+v, want job2 before job1", got)
+			}
+			if got[0].Remaining <= 0 || got[0].Remaining > 30 || got[1].Remaining <= 30 || got[1].Remaining > 60 {
+				t.Errorf("pendingHandler() remaining = %v and %v, want up to 30 and 60", got[0].Remaining, got[1].Remaining)
+			}
+			if time.Until(got[1].FiresAt) > time.Minute {
+				t.Errorf("pendingHandler() fires_at = %v, want within a minute", got[1].FiresAt)
+			}
+		})
+	}
+}
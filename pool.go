@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// defaultMaxConcurrency is the number of trigger workers if not configured
+const defaultMaxConcurrency = 4
+
+// triggerRequest is a job waiting for a free trigger worker
+type triggerRequest struct {
+	target jenkinsTarget
+	job    string
+	params url.Values
+	// repo and branch are the push the trigger originates from
+	repo   string
+	branch string
+	// sync is set for the triggers of synchronous requests, which wait for
+	// the build themselves instead of tracking it
+	sync bool
+	// logger carries the attributes of the request the trigger originates
+	// from, the default logger is used if it is nil
+	logger *slog.Logger
+	// done is closed once the job was triggered
+	done chan struct{}
+	// status, location and err are the result of triggerFunc, they are set
+	// before done is closed
+	status   int
+	location string
+	err      error
+}
+
+// triggerPool triggers queued jobs with a fixed number of workers, jobs
+// beyond the number of workers wait in the queue
+type triggerPool struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	queue []*triggerRequest
+	// inFlight counts the queued and running triggers
+	inFlight sync.WaitGroup
+	// ctx is passed to every trigger, cancel aborts the running triggers
+	ctx    context.Context
+	cancel context.CancelFunc
+	// trigger triggers the job of a request
+	trigger triggerFunc
+}
+
+// triggerFunc triggers a job like triggerJob
+type triggerFunc func(ctx context.Context, target jenkinsTarget, job string, params url.Values, cause string) (int, string, error)
+
+// newTriggerPool returns a pool running the given number of workers, which
+// trigger the jobs with trigger
+func newTriggerPool(workers int, trigger triggerFunc) *triggerPool {
+	p := &triggerPool{trigger: trigger}
+	p.cond = sync.NewCond(&p.mu)
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// enqueue adds the request to the queue, it never blocks
+func (p *triggerPool) enqueue(req *triggerRequest) {
+	if req.logger == nil {
+		req.logger = slog.Default()
+	}
+	p.inFlight.Add(1)
+
+	p.mu.Lock()
+	p.queue = append(p.queue, req)
+	queued := len(p.queue)
+	p.mu.Unlock()
+
+	req.logger.Debug("Trigger queued", "job", req.job, "queued", queued)
+	p.cond.Signal()
+}
+
+func (p *triggerPool) work() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 {
+			p.cond.Wait()
+		}
+		req := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+
+		ctx := withLogger(p.ctx, req.logger)
+		req.status, req.location, req.err = p.trigger(ctx, req.target, req.job, req.params, causeFor(req.repo, req.branch))
+		if req.err != nil {
+			logTriggerError(req.logger, req.job, req.err)
+		} else {
+			lastTriggered.set(float64(time.Now().Unix()), req.repo, req.branch, req.job)
+		}
+		close(req.done)
+		p.inFlight.Done()
+	}
+}
+
+// wait waits until all queued and running triggers are done or the
+// timeout passed, triggers still running then are cancelled
+func (p *triggerPool) wait(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		p.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		p.cancel()
+		return errors.New("timed out waiting for in-flight triggers")
+	}
+}
+
+// enqueueTrigger queues the request on the trigger pool. The returned
+// channel is closed once the job was triggered. The builds of triggers not
+// made for synchronous requests are tracked with TrackBuilds.
+func (s *Server) enqueueTrigger(req *triggerRequest) <-chan struct{} {
+	req.done = make(chan struct{})
+	s.triggerPool().enqueue(req)
+
+	if TrackBuilds && !req.sync {
+		go s.trackTrigger(req)
+	}
+
+	return req.done
+}
+
+// trackTrigger waits until the job of the request was triggered and tracks
+// its build
+func (s *Server) trackTrigger(req *triggerRequest) {
+	<-req.done
+	if req.err == nil && isQueueItem(req.status, req.location) {
+		s.trackBuild(req.logger, req.target, req.job, req.location)
+	}
+}
+
+// waitForTriggers waits up to timeout for the triggers of the trigger pool
+func (s *Server) waitForTriggers(timeout time.Duration) error {
+	return s.triggerPool().wait(timeout)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_triggerPool(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight, triggered := 0, 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		triggered++
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	pool := newTriggerPool(2, newTestServer(t).triggerJob)
+	target := jenkinsTarget{URL: ts.URL}
+
+	var requests []*triggerRequest
+	for i := 0; i < 10; i++ {
+		req := &triggerRequest{target: target, job: "job", done: make(chan struct{})}
+		pool.enqueue(req)
+		requests = append(requests, req)
+	}
+
+	for _, req := range requests {
+		select {
+		case <-req.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("triggerPool did not trigger all queued jobs")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if triggered != 10 {
+		t.Errorf("triggerPool triggered %d jobs, want 10", triggered)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("triggerPool sent %d concurrent requests, want at most 2", maxInFlight)
+	}
+}
+
+func Test_triggerPoolLastTriggered(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/job/missing/build" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	pool := newTriggerPool(1, newTestServer(t).triggerJob)
+	target := jenkinsTarget{URL: ts.URL}
+	before := float64(time.Now().Unix())
+
+	for _, job := range []string{"job", "missing"} {
+		req := &triggerRequest{target: target, job: job, repo: "git://last", branch: "master", done: make(chan struct{})}
+		pool.enqueue(req)
+		<-req.done
+	}
+
+	lastTriggered.mu.Lock()
+	defer lastTriggered.mu.Unlock()
+	if got := lastTriggered.sample([]string{"git://last", "master", "job"}).value; got < before {
+		t.Errorf("lastTriggered = %v, want at least %v", got, before)
+	}
+	if _, ok := lastTriggered.samples["git://last\xffmaster\xffmissing"]; ok {
+		t.Errorf("lastTriggered set for a failed trigger")
+	}
+}
+
+func Test_triggerPoolWait(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	pool := newTriggerPool(1, newTestServer(t).triggerJob)
+	for i := 0; i < 2; i++ {
+		pool.enqueue(&triggerRequest{target: jenkinsTarget{URL: ts.URL}, job: "job", done: make(chan struct{})})
+	}
+
+	if err := pool.wait(50 * time.Millisecond); err == nil {
+		t.Errorf("wait() expected timeout while triggers are in flight")
+	}
+
+	close(release)
+	if err := pool.wait(5 * time.Second); err != nil {
+		t.Errorf("wait() error = %v", err)
+	}
+}
+
+func Test_triggerPoolWaitCancels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	pool := newTriggerPool(1, newTestServer(t).triggerJob)
+	req := &triggerRequest{target: jenkinsTarget{URL: ts.URL}, job: "job", done: make(chan struct{})}
+	pool.enqueue(req)
+
+	if err := pool.wait(50 * time.Millisecond); err == nil {
+		t.Errorf("wait() expected timeout while triggers are in flight")
+	}
+
+	select {
+	case <-req.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait() did not cancel the in-flight trigger")
+	}
+}
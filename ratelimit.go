@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// maxLimitedRepos bounds the number of repos the rate limiter keeps
+	// state for
+	maxLimitedRepos = 10000
+	// limiterSweepInterval is the minimum time between two sweeps of idle
+	// repos
+	limiterSweepInterval = time.Minute
+)
+
+// tokenBucket is the rate limiting state of a single repo
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket rate limiter keyed by repo. Buckets which
+// are refilled completely are equal to new ones and are dropped.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// newRateLimiter returns a limiter allowing rate requests per second and
+// bursts of up to burst requests per key
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token of the key and reports whether one was available,
+// otherwise it returns the time until the next token is available
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= limiterSweepInterval || len(l.buckets) >= maxLimitedRepos {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+// sweep drops the buckets which are refilled completely, if the limiter is
+// still full afterwards the least recently used bucket is dropped
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+
+	var oldestKey string
+	var oldest time.Time
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+			continue
+		}
+		if oldestKey == "" || b.last.Before(oldest) {
+			oldestKey, oldest = key, b.last
+		}
+	}
+
+	if len(l.buckets) >= maxLimitedRepos {
+		delete(l.buckets, oldestKey)
+	}
+}
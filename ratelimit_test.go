@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_rateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(1, 2)
+	l.now = func() time.Time { return now }
+
+	steps := []struct {
+		name     string
+		advance  time.Duration
+		key      string
+		want     bool
+		wantWait time.Duration
+	}{
+		{"first", 0, "a", true, 0},
+		{"burst", 0, "a", true, 0},
+		{"exceeded", 0, "a", false, time.Second},
+		{"other repo", 0, "b", true, 0},
+		{"half refilled", 500 * time.Millisecond, "a", false, 500 * time.Millisecond},
+		{"refilled", 500 * time.Millisecond, "a", true, 0},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		got, wait := l.allow(step.key)
+		if got != step.want || wait != step.wantWait {
+			t.Errorf("%s: allow() = %v, %v, want %v, %v", step.name, got, wait, step.want, step.wantWait)
+		}
+	}
+
+	now = now.Add(limiterSweepInterval)
+	l.allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Errorf("allow() kept the bucket of the idle repo a")
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("allow() kept %d buckets, want 1", len(l.buckets))
+	}
+}
+
+func TestHandlerRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.mapping = map[string][]jobMapping{"git://repo|master": {{Name: "job1"}}}
+	s.config.QuietPeriod = 60 * time.Second
+	s.limiter = newRateLimiter(0.5, 1)
+
+	w := httptest.NewRecorder()
+	s.handler(w, httptest.NewRequest("GET", "/?repo=git://repo", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("handler() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	stopTimers(s)
+	w = httptest.NewRecorder()
+	s.handler(w, httptest.NewRequest("GET", "/?repo=git://repo", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("handler() status = %v, want %v", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("handler() Retry-After = %q, want 2", got)
+	}
+
+	s.timeKeeperMu.Lock()
+	defer s.timeKeeperMu.Unlock()
+	if len(s.timeKeeper) != 0 {
+		t.Errorf("handler() created %d timers for a rate limited request", len(s.timeKeeper))
+	}
+}
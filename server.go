@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Config is the configuration of a Server, run builds it from the flags.
+// The remaining flags, e.g. the retries and the transport of the requests
+// to jenkins, the tokens of the admin endpoints and the shutdown, are still
+// read from the flag variables.
+type Config struct {
+	// Addr is the address Serve listens on, defaultAddr if empty
+	Addr string
+	// MappingFile is the comma separated list of mapping files and globs
+	// the server loads and reloads
+	MappingFile string
+	// FileMatching keeps the file patterns of the mapping files, jobs are
+	// then only triggered for matching changed files
+	FileMatching bool
+	// WatchMapping reloads the mapping files when they change
+	WatchMapping bool
+	// Once stops Serve once the first request scheduling jobs is handled
+	// and its jobs are triggered
+	Once bool
+	// Jenkins is the jenkins jobs are triggered on if their mapping names
+	// none, its credentials are used for mapped jenkins without any
+	Jenkins jenkinsTarget
+	// QuietPeriod is the quiet period of jobs without their own
+	QuietPeriod time.Duration
+	// MaxWait caps the delay of a job by repeated requests, unlimited if 0
+	MaxWait time.Duration
+	// Workers is the number of concurrent triggers, defaultMaxConcurrency
+	// if not positive
+	Workers int
+	// AllowedRepos holds the repos requests are accepted for, all repos are
+	// allowed if it is empty
+	AllowedRepos map[string]bool
+	// RateLimit is the number of requests per second per repo with bursts
+	// of up to RateLimitBurst, requests are not limited if it is 0
+	RateLimit      float64
+	RateLimitBurst int
+	// BreakerThreshold is the number of consecutive failed triggers which
+	// open the circuit of a jenkins for BreakerCooldown, the breaker is
+	// disabled if it is 0. Triggers to an open circuit are held back with
+	// BreakerQueue and skipped otherwise.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	BreakerQueue     bool
+	// DedupeWindow is the time redeliveries of a webhook are skipped,
+	// disabled if 0
+	DedupeWindow time.Duration
+	// IncomingToken is the token requests to /trigger have to send, any
+	// request is accepted if it is empty
+	IncomingToken string
+	// WebhookSecret verifies the signature of github webhooks if set
+	WebhookSecret string
+	// MaxBodyBytes limits the size of request bodies, unlimited if 0
+	MaxBodyBytes int64
+	// DebugResponses lists the mapping keys of the repo in responses of
+	// requests without mapping
+	DebugResponses bool
+	// CatchAll triggers the jobs of repo * for repos without mapping
+	CatchAll bool
+	// NormalizeRepo reduces repo urls to their path before the lookup
+	NormalizeRepo bool
+}
+
+// Server is a trigger-proxy instance. It holds the loaded mapping, the
+// pending quiet period timers, the trigger pool and the state of the
+// requests to jenkins.
+type Server struct {
+	config Config
+
+	// mappingMu guards the mapping and the state of its loading
+	mappingMu sync.RWMutex
+	mapping   map[string][]jobMapping
+	// branchPatterns holds the wildcard branch patterns of mapping per repo
+	branchPatterns map[string][]string
+	// mappingLoaded and mappingLoadErr describe the state of the mapping
+	// file loading
+	mappingLoaded  bool
+	mappingLoadErr error
+	// mappingMissingCount is the number of consecutive loads which failed
+	// since the mapping file is missing
+	mappingMissingCount int
+	// mappingSource and mappingLoadedAt describe the loaded mapping file
+	mappingSource   string
+	mappingLoadedAt time.Time
+
+	timeKeeperMu sync.Mutex
+	timeKeeper   map[string]*pendingTrigger
+
+	// the trigger pool is started on first use
+	poolOnce sync.Once
+	pool     *triggerPool
+	// trigger triggers the jobs of the pool and of /trigger-now, tests
+	// replace it to record the triggers without a jenkins
+	trigger triggerFunc
+
+	// jenkins keeps the session and crumbs of the jenkins masters
+	jenkins *jenkinsSession
+	// limiter limits the requests per repo, it is nil if rate limiting is
+	// disabled
+	limiter *rateLimiter
+	// breaker stops triggers to failing jenkins masters, it is nil if the
+	// circuit breaker is disabled
+	breaker *circuitBreaker
+	// deliveries holds the recent webhook deliveries, it is nil if
+	// redeliveries are not skipped
+	deliveries *deliveryCache
+
+	// handled is closed after the first request scheduling jobs, Serve
+	// waits for it with Once
+	handled     chan struct{}
+	handledOnce sync.Once
+}
+
+// NewServer returns a server with the config and an empty mapping
+func NewServer(config Config) *Server {
+	if config.Workers < 1 {
+		config.Workers = defaultMaxConcurrency
+	}
+	if config.Addr == "" {
+		config.Addr = defaultAddr
+	}
+
+	s := &Server{
+		config:         config,
+		mapping:        make(map[string][]jobMapping),
+		branchPatterns: make(map[string][]string),
+		timeKeeper:     make(map[string]*pendingTrigger),
+		jenkins:        newJenkinsSession(),
+		handled:        make(chan struct{}),
+	}
+	s.trigger = s.triggerJob
+	if config.RateLimit > 0 {
+		s.limiter = newRateLimiter(config.RateLimit, config.RateLimitBurst)
+	}
+	if config.BreakerThreshold > 0 {
+		s.breaker = newCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown, config.BreakerQueue)
+	}
+	if config.DedupeWindow > 0 {
+		s.deliveries = newDeliveryCache(config.DedupeWindow)
+	}
+
+	return s
+}
+
+// triggerPool returns the trigger pool of the server, it is started on
+// first use
+func (s *Server) triggerPool() *triggerPool {
+	s.poolOnce.Do(func() {
+		s.pool = newTriggerPool(s.config.Workers, s.trigger)
+	})
+
+	return s.pool
+}
+
+// Serve serves the routes below basePath on the Addr of the config, with
+// https if tlsConfig is given, and reloads the mapping file on SIGHUP. It
+// shuts down on SIGINT or SIGTERM, or with Once after the first handled
+// request, and
+// waits for the pending triggers.
+func (s *Server) Serve(basePath string, tlsConfig *tls.Config) error {
+	go s.reloadOnSignal()
+
+	if s.config.WatchMapping {
+		// globs are expanded once, files added later are picked up on SIGHUP
+		paths, _ := mappingFilePaths(s.config.MappingFile)
+		if len(paths) > 0 {
+			go watchMappingFiles(paths, time.Second, 2*time.Second, s.reloadMappingFile, s.mappingFileMissing, nil)
+		}
+	}
+
+	server := &http.Server{Addr: s.config.Addr, Handler: s.newRouter(basePath), TLSConfig: tlsConfig}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			log.Printf("Serving https on %s\n", s.config.Addr)
+			serveErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		log.Printf("Serving on %s\n", s.config.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	var handled <-chan struct{}
+	if s.config.Once {
+		log.Println("Exiting after the first handled request")
+		handled = s.handled
+	}
+
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %v, shutting down\n", sig)
+	case <-handled:
+		log.Println("Request handled, waiting for pending timers")
+		if sig := s.waitForTimers(stop); sig != nil {
+			log.Printf("Received %v, shutting down\n", sig)
+		} else {
+			log.Println("Pending timers fired, shutting down")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), GracePeriod)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	if FlushOnShutdown {
+		if err := s.flushTimers(ctx); err != nil {
+			return fmt.Errorf("flushing pending jobs: %v", err)
+		}
+	}
+
+	if err := s.waitForTriggers(ShutdownTimeout); err != nil {
+		return err
+	}
+
+	log.Println("Shutdown complete")
+
+	return nil
+}
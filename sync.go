@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+const (
+	// buildResultSuccess is the result of a successful jenkins build
+	buildResultSuccess = "SUCCESS"
+	// buildResultDryRun is the result of jobs not triggered with DryRun
+	buildResultDryRun = "DRY_RUN"
+)
+
+// syncResponse is the response of a synchronous request with the results
+// of the builds of its jobs
+type syncResponse struct {
+	Key  string        `json:"key"`
+	Jobs []syncJobJSON `json:"jobs"`
+}
+
+// syncJobJSON is the result of the build of a job of a synchronous request
+type syncJobJSON struct {
+	Name    string `json:"name"`
+	Jenkins string `json:"jenkins,omitempty"`
+	Result  string `json:"result,omitempty"`
+	Build   int    `json:"build,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// errSyncTimeout is reported for builds which did not finish in SyncTimeout
+var errSyncTimeout = errors.New("timed out waiting for the build")
+
+// isSyncRequest reports whether the request waits for the builds of its
+// jobs, either with Sync or with the sync query parameter
+func isSyncRequest(r *http.Request) bool {
+	if Sync {
+		return true
+	}
+
+	enabled, _ := strconv.ParseBool(r.URL.Query().Get("sync"))
+
+	return enabled
+}
+
+// handleSync triggers the jobs right away instead of starting their quiet
+// period and responds with the results of their builds once all finished
+// or SyncTimeout passed. The response is 200 if every build succeeded or
+// DryRun is set, 504 after the timeout and 502 otherwise.
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request, logger *slog.Logger, key, repo, branch string, jobs []jobMapping, reqParams url.Values) {
+	ctx, cancel := context.WithTimeout(r.Context(), SyncTimeout)
+	defer cancel()
+
+	resp := syncResponse{Key: key, Jobs: make([]syncJobJSON, len(jobs))}
+
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp.Jobs[i] = s.buildJob(ctx, logger, repo, branch, job, job.buildParams(reqParams))
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, job := range resp.Jobs {
+		if job.Result != buildResultSuccess && job.Result != buildResultDryRun {
+			status = http.StatusBadGateway
+		}
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+
+	logger.Info("Handling synchronous request finished", "event", "request_handled",
+		"repo", repo, "branch", branch, "jobs", len(jobs), "status", status)
+}
+
+// buildJob triggers the job on the trigger pool and waits until its build
+// finished, with DryRun the result is buildResultDryRun
+func (s *Server) buildJob(ctx context.Context, logger *slog.Logger, repo, branch string, job jobMapping, params url.Values) syncJobJSON {
+	result := syncJobJSON{Name: job.Name}
+	if job.Target != nil {
+		result.Jenkins = job.Target.URL
+	}
+
+	fail := func(err error) syncJobJSON {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = errSyncTimeout
+		}
+		result.Error = err.Error()
+		return result
+	}
+
+	target := job.target(s.config.Jenkins)
+	req := &triggerRequest{target: target, job: job.Name, params: params, repo: repo, branch: branch, sync: true, logger: logger}
+	select {
+	case <-s.enqueueTrigger(req):
+	case <-ctx.Done():
+		return fail(ctx.Err())
+	}
+	if req.err != nil {
+		return fail(req.err)
+	}
+
+	if DryRun {
+		result.Result = buildResultDryRun
+		return result
+	}
+
+	if !isQueueItem(req.status, req.location) {
+		return fail(errors.New("jenkins did not return a queue item"))
+	}
+
+	number, buildURL, err := s.waitForBuild(ctx, target, req.location)
+	if err != nil {
+		return fail(err)
+	}
+	result.Build, result.URL = number, buildURL
+
+	buildResult, err := s.waitForResult(ctx, target, buildURL)
+	if err != nil {
+		return fail(err)
+	}
+	result.Result = buildResult
+
+	logger.Info("Build finished", "event", "build_finished", "job", job.Name, "build", number, "url", buildURL, "result", buildResult)
+
+	return result
+}
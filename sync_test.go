@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandlerSync(t *testing.T) {
+	var mu sync.Mutex
+	polls := make(map[string]int)
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		job, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/job/"), "/")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/queue/item/"):
+			job := strings.Split(r.URL.Path, "/")[3]
+			w.Write([]byte(`{"executable":{"number":7,"url":"` + ts.URL + `/job/` + job + `/7/"}}`))
+		case rest == "build":
+			w.Header().Set("Location", ts.URL+"/queue/item/"+job+"/")
+			w.WriteHeader(http.StatusCreated)
+		case rest == "7/api/json":
+			polls[job]++
+			switch {
+			case job == "running" || polls[job] < 2:
+				w.Write([]byte(`{"building":true,"result":null}`))
+			case job == "broken":
+				w.Write([]byte(`{"building":false,"result":"FAILURE"}`))
+			default:
+				w.Write([]byte(`{"building":false,"result":"SUCCESS"}`))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	queuePollInterval, buildPollInterval = time.Millisecond, time.Millisecond
+	SyncTimeout = time.Minute
+	defer func() {
+		queuePollInterval, buildPollInterval = 2*time.Second, 5*time.Second
+		SyncTimeout = 30 * time.Minute
+	}()
+
+	s := newTestServer(t)
+	s.config.Jenkins = jenkinsTarget{URL: ts.URL, RootURL: ts.URL}
+	s.mapping = map[string][]jobMapping{
+		"git://repo|main":    {{Name: "build"}},
+		"git://repo|release": {{Name: "build"}, {Name: "broken"}},
+		"git://repo|slow":    {{Name: "running"}},
+	}
+	s.config.QuietPeriod = 60 * time.Second
+
+	tests := []struct {
+		name        string
+		branch      string
+		syncTimeout time.Duration
+		wantStatus  int
+		want        []syncJobJSON
+	}{
+		{
+			"success", "main", time.Minute, http.StatusOK,
+			[]syncJobJSON{{Name: "build", Result: "SUCCESS", Build: 7, URL: ts.URL + "/job/build/7/"}},
+		},
+		{
+			"failure", "release", time.Minute, http.StatusBadGateway,
+			[]syncJobJSON{
+				{Name: "build", Result: "SUCCESS", Build: 7, URL: ts.URL + "/job/build/7/"},
+				{Name: "broken", Result: "FAILURE", Build: 7, URL: ts.URL + "/job/broken/7/"},
+			},
+		},
+		{
+			"timeout", "slow", 50 * time.Millisecond, http.StatusGatewayTimeout,
+			[]syncJobJSON{{Name: "running", Build: 7, URL: ts.URL + "/job/running/7/", Error: errSyncTimeout.Error()}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SyncTimeout = tt.syncTimeout
+			w := httptest.NewRecorder()
+			s.handler(w, httptest.NewRequest("GET", "/?repo=git://repo&branch="+tt.branch+"&sync=true", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("handler() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			var resp syncResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp.Jobs, tt.want) {
+				t.Errorf("handler() jobs = %+v, want %+v", resp.Jobs, tt.want)
+			}
+		})
+	}
+
+	if pending := len(s.timeKeeper); pending != 0 {
+		t.Errorf("handler() started %d timers for synchronous requests", pending)
+	}
+}
+
+func TestHandlerSyncDryRun(t *testing.T) {
+	s := newTestServer(t)
+	triggered := stubTrigger(s)
+	s.mapping = map[string][]jobMapping{"git://repo|main": {{Name: "build"}}}
+	DryRun = true
+	SyncTimeout = time.Minute
+	defer func() {
+		DryRun = false
+		SyncTimeout = 30 * time.Minute
+	}()
+
+	w := httptest.NewRecorder()
+	s.handler(w, httptest.NewRequest("GET", "/?repo=git://repo&branch=main&sync=true", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("handler() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	var resp syncResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if want := []syncJobJSON{{Name: "build", Result: buildResultDryRun}}; !reflect.DeepEqual(resp.Jobs, want) {
+		t.Errorf("handler() jobs = %+v, want %+v", resp.Jobs, want)
+	}
+
+	select {
+	case got := <-triggered:
+		if got != "build" {
+			t.Errorf("handler() triggered %v, want build", got)
+		}
+	default:
+		t.Error("handler() did not trigger the job on the trigger pool")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJenkins is a jenkins stub recording the trigger requests it receives
+type fakeJenkins struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	// failures is the number of requests answered with 503 before the
+	// stub accepts triggers
+	failures int
+}
+
+func newFakeJenkins(t *testing.T, failures int) *fakeJenkins {
+	f := &fakeJenkins{failures: failures}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.requests = append(f.requests, r)
+		if len(f.requests) <= f.failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Location", f.URL+"/queue/item/1/")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeJenkins) received() []*http.Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*http.Request(nil), f.requests...)
+}
+
+func Test_triggerJobFakeJenkins(t *testing.T) {
+	s := newTestServer(t)
+	defer func() {
+		MaxRetries = 0
+		retryBackoff = time.Second
+	}()
+
+	tests := []struct {
+		name      string
+		user      string
+		job       string
+		params    url.Values
+		cause     string
+		failures  int
+		retries   int
+		wantCalls int
+		wantPath  string
+		wantQuery url.Values
+		wantErr   bool
+	}{
+		{
+			name: "basic auth", user: "user", job: "job",
+			wantCalls: 1, wantPath: "/job/job/build", wantQuery: url.Values{},
+		},
+		{
+			name: "anonymous token", job: "job",
+			wantCalls: 1, wantPath: "/job/job/build", wantQuery: url.Values{"token": {"secret"}},
+		},
+		{
+			name: "anonymous token with parameters", job: "job", params: url.Values{"BRANCH": {"main"}},
+			wantCalls: 1, wantPath: "/job/job/buildWithParameters", wantQuery: url.Values{"BRANCH": {"main"}, "token": {"secret"}},
+		},
+		{
+			name: "cause", user: "user", job: "job", cause: "Triggered by trigger-proxy for git://repo branch main",
+			wantCalls: 1, wantPath: "/job/job/build", wantQuery: url.Values{"cause": {"Triggered by trigger-proxy for git://repo branch main"}},
+		},
+		{
+			name: "nested job", user: "user", job: "teamA/sub/service B",
+			wantCalls: 1, wantPath: "/job/teamA/job/sub/job/service B/build", wantQuery: url.Values{},
+		},
+		{
+			name: "retries on 503", user: "user", job: "job", failures: 2, retries: 3,
+			wantCalls: 3, wantPath: "/job/job/build", wantQuery: url.Values{},
+		},
+		{
+			name: "gives up on 503", user: "user", job: "job", failures: 10, retries: 1,
+			wantCalls: 2, wantPath: "/job/job/build", wantQuery: url.Values{}, wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jenkins := newFakeJenkins(t, tt.failures)
+			MaxRetries = tt.retries
+			retryBackoff = time.Millisecond
+
+			target := jenkinsTarget{URL: jenkins.URL, RootURL: jenkins.URL, User: tt.user, Token: "secret"}
+			_, _, err := s.triggerJob(context.Background(), target, tt.job, tt.params, tt.cause)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("triggerJob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			requests := jenkins.received()
+			if len(requests) != tt.wantCalls {
+				t.Fatalf("triggerJob() sent %d requests, want %d", len(requests), tt.wantCalls)
+			}
+
+			for _, r := range requests {
+				if r.Method != http.MethodPost {
+					t.Errorf("triggerJob() method = %v, want POST", r.Method)
+				}
+				if r.URL.Path != tt.wantPath {
+					t.Errorf("triggerJob() path = %v, want %v", r.URL.Path, tt.wantPath)
+				}
+				if got := r.URL.Query(); !reflect.DeepEqual(got, tt.wantQuery) {
+					t.Errorf("triggerJob() query = %v, want %v", got, tt.wantQuery)
+				}
+
+				user, token, ok := r.BasicAuth()
+				if tt.user == "" {
+					if ok {
+						t.Errorf("triggerJob() sent basic auth for an anonymous trigger")
+					}
+				} else if user != tt.user || token != "secret" {
+					t.Errorf("triggerJob() basic auth = %v:%v, want %v:secret", user, token, tt.user)
+				}
+			}
+		})
+	}
+}
+
+func Test_causeFor(t *testing.T) {
+	defer func() { Cause = "" }()
+
+	tests := []struct {
+		name  string
+		cause string
+		want  string
+	}{
+		{"no cause", "", ""},
+		{"plain cause", "trigger-proxy", "trigger-proxy"},
+		{"templated cause", "Triggered by proxy for repo {repo} branch {branch}", "Triggered by proxy for repo git://repo branch feature/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Cause = tt.cause
+			if got := causeFor("git://repo", "feature/x"); got != tt.want {
+				t.Errorf("causeFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_triggerJobCancelled(t *testing.T) {
+	s := newTestServer(t)
+	jenkins := newFakeJenkins(t, 10)
+	MaxRetries = 5
+	retryBackoff = time.Minute
+	defer func() {
+		MaxRetries = 0
+		retryBackoff = time.Second
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	target := jenkinsTarget{URL: jenkins.URL, RootURL: jenkins.URL, User: "user", Token: "secret"}
+	if _, _, err := s.triggerJob(ctx, target, "job", nil, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("triggerJob() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("triggerJob() took %v after the context was done", elapsed)
+	}
+	if calls := len(jenkins.received()); calls != 1 {
+		t.Errorf("triggerJob() sent %d requests, want 1", calls)
+	}
+}
+
+func Test_triggerJobRedirect(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name       string
+		location   string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"redirect to the job", "/job/job/", http.StatusFound, false},
+		{"redirect without location", "", http.StatusFound, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			var mu sync.Mutex
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				calls++
+				mu.Unlock()
+				if r.URL.Path != "/job/job/build" {
+					t.Errorf("redirect was followed to %s", r.URL.Path)
+				}
+				if tt.location != "" {
+					w.Header().Set("Location", tt.location)
+				}
+				w.WriteHeader(http.StatusFound)
+			}))
+			defer ts.Close()
+
+			target := jenkinsTarget{URL: ts.URL, RootURL: ts.URL, User: "user", Token: "secret"}
+			status, _, err := s.triggerJob(context.Background(), target, "job", nil, "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("triggerJob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("triggerJob() status = %v, want %v", status, tt.wantStatus)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if calls != 1 {
+				t.Errorf("triggerJob() sent %d requests, want 1", calls)
+			}
+		})
+	}
+}
+
+func Test_triggerJobRetryAfter(t *testing.T) {
+	s := newTestServer(t)
+	MaxRetries = 1
+	retryBackoff = time.Minute
+	defer func() {
+		MaxRetries = 0
+		retryBackoff = time.Second
+		MaxRetryAfter = time.Minute
+	}()
+
+	tests := []struct {
+		name          string
+		retryAfter    string
+		maxRetryAfter time.Duration
+	}{
+		{"seconds", "0", time.Minute},
+		{"http date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), time.Minute},
+		{"capped", "3600", 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			MaxRetryAfter = tt.maxRetryAfter
+			var calls int
+			var mu sync.Mutex
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+				if calls == 1 {
+					w.Header().Set("Retry-After", tt.retryAfter)
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer ts.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			target := jenkinsTarget{URL: ts.URL, RootURL: ts.URL, User: "user", Token: "secret"}
+			if _, _, err := s.triggerJob(ctx, target, "job", nil, ""); err != nil {
+				t.Fatalf("triggerJob() error = %v, the retry did not honor Retry-After", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if calls != 2 {
+				t.Errorf("triggerJob() sent %d requests, want 2", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// watchMappingFiles polls the files at paths every interval and calls reload
+// once the changed files have not changed for the debounce duration. A poll
+// finding files missing calls missing once with the path of the first, to
+// count the absence for UnhealthyAfter. The files are stat'ed through
+// symlinks, so replacing them, as done for mounted Kubernetes ConfigMaps, is
+// detected as well as writing them in place.
+func watchMappingFiles(paths []string, interval, debounce time.Duration, reload func(), missing func(path string), stop <-chan struct{}) {
+	last := make([]os.FileInfo, len(paths))
+	// gone holds the files found missing by the previous poll, they are
+	// only logged once
+	gone := make([]bool, len(paths))
+	for i, path := range paths {
+		log.Printf("Watching mapping file: %s\n", path)
+		last[i], _ = os.Stat(path)
+	}
+	var lastChange time.Time
+	pending := false
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			missingPath := ""
+			for i, path := range paths {
+				current, err := os.Stat(path)
+				if err != nil {
+					// the file might be in the middle of being replaced
+					last[i] = nil
+					if errors.Is(err, fs.ErrNotExist) {
+						if !gone[i] {
+							slog.Warn("Mapping file is missing", "event", "mapping_missing", "file", path)
+							gone[i] = true
+						}
+						if missingPath == "" {
+							missingPath = path
+						}
+					}
+					continue
+				}
+
+				gone[i] = false
+				if fileChanged(last[i], current) {
+					last[i] = current
+					lastChange = now
+					pending = true
+				}
+			}
+
+			// reloading fails anyway while a file is missing
+			if missingPath != "" {
+				missing(missingPath)
+				continue
+			}
+
+			if pending && now.Sub(lastChange) >= debounce {
+				pending = false
+				log.Println("Mapping file changed")
+				reload()
+			}
+		}
+	}
+}
+
+func fileChanged(last, current os.FileInfo) bool {
+	if last == nil {
+		return true
+	}
+
+	return !os.SameFile(last, current) ||
+		!last.ModTime().Equal(current.ModTime()) ||
+		last.Size() != current.Size()
+}
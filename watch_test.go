@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_watchMappingFiles(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "mapping.csv")
+
+	// mimic the layout of a mounted ConfigMap
+	writeVersion := func(version, content string) {
+		versionDir := filepath.Join(dir, version)
+		if err := os.Mkdir(versionDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(versionDir, "mapping.csv"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tmp := filepath.Join(dir, "..data_tmp")
+		if err := os.Symlink(versionDir, tmp); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeVersion("v1", "git://repo;master;job1")
+	if err := os.Symlink(filepath.Join("..data", "mapping.csv"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go watchMappingFiles([]string{link}, 5*time.Millisecond, 50*time.Millisecond, func() { reloads <- struct{}{} }, func(string) {}, stop)
+
+	expectReloads := func(want int) {
+		t.Helper()
+		time.Sleep(200 * time.Millisecond)
+		if got := len(reloads); got != want {
+			t.Errorf("watchMappingFiles() reloaded %d times, want %d", got, want)
+		}
+		for len(reloads) > 0 {
+			<-reloads
+		}
+	}
+
+	expectReloads(0)
+
+	// a burst of in place writes results in a single reload
+	target := filepath.Join(dir, "v1", "mapping.csv")
+	for _, content := range []string{"a;b;c", "a;b;cd", "a;b;cde"} {
+		if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectReloads(1)
+
+	writeVersion("v2", "a;b;cde")
+	expectReloads(1)
+}
+
+func Test_watchMappingFilesMissing(t *testing.T) {
+	s := newTestServer(t)
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "team-a.csv"), filepath.Join(dir, "team-b.csv")}
+	s.config.MappingFile = strings.Join(paths, ",")
+	UnhealthyAfter = 5
+	defer func() { UnhealthyAfter = 0 }()
+
+	for _, path := range paths {
+		if err := os.WriteFile(path, []byte("git://repo;master;job1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.ProcessMappingFile(s.config.MappingFile); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultLogger := slog.Default()
+	defer slog.SetDefault(defaultLogger)
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	// the watcher has to be gone before UnhealthyAfter and the logger are
+	// reset and the log is read
+	interval := 20 * time.Millisecond
+	stop, done := make(chan struct{}), make(chan struct{})
+	stopWatcher := func() {
+		if stop != nil {
+			close(stop)
+			<-done
+			stop = nil
+		}
+	}
+	defer stopWatcher()
+	go func() {
+		defer close(done)
+		watchMappingFiles(paths, interval, 50*time.Millisecond, s.reloadMappingFile, s.mappingFileMissing, stop)
+	}()
+
+	healthz := func() int {
+		w := httptest.NewRecorder()
+		s.healthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+		return w.Code
+	}
+	if got := healthz(); got != http.StatusOK {
+		t.Fatalf("healthzHandler() status = %v before the files were removed, want %v", got, http.StatusOK)
+	}
+
+	removed := time.Now()
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := removed.Add(5 * time.Second)
+	for healthz() != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatal("healthzHandler() stayed healthy after the watched mapping files were removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// every poll counts once for both files, the threshold is reached
+	// after UnhealthyAfter polls at the earliest
+	if elapsed, want := time.Since(removed), time.Duration(UnhealthyAfter-1)*interval; elapsed < want {
+		t.Errorf("healthzHandler() unhealthy after %v, want at least %v", elapsed, want)
+	}
+
+	// keep polling the missing files for a while
+	time.Sleep(5 * interval)
+	stopWatcher()
+
+	logged := 0
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		if line["event"] == "mapping_missing" {
+			logged++
+		}
+	}
+	if logged != len(paths) {
+		t.Errorf("watchMappingFiles() logged %d missing files, want %d", logged, len(paths))
+	}
+}
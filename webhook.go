@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// webhookCommit is the commit representation shared by the push payloads of
+// GitHub, Gitea and GitLab
+type webhookCommit struct {
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
+
+// WebhookRequest is a push as parsed from an incoming request
+type WebhookRequest struct {
+	Repo string
+	// Branch is the pushed branch, empty for tag pushes
+	Branch string
+	// Tag is the pushed tag, empty for branch pushes
+	Tag string
+	// DefaultBranch is the default branch of the repo as sent by the
+	// webhook, DefaultBranch if the payload does not include it
+	DefaultBranch string
+	// Files are all changed files of the push, Changes the same files by
+	// change type
+	Files   []string
+	Changes fileChanges
+	// Params are the request parameters passed to parameterized jobs
+	Params url.Values
+}
+
+// newWebhookRequest returns the request for a push of ref to repo, ref is a
+// branch or a tag with the tag: prefix as returned by branchFromRef
+func newWebhookRequest(r *http.Request, repo, ref string, files []string) *WebhookRequest {
+	webhook := &WebhookRequest{Repo: repo, DefaultBranch: DefaultBranch, Files: files, Params: requestParams(r, ref)}
+
+	if tag, ok := strings.CutPrefix(ref, tagPrefix); ok {
+		webhook.Tag = tag
+	} else {
+		webhook.Branch = ref
+	}
+
+	return webhook
+}
+
+// fileChanges are the changed files of a push by change type
+type fileChanges struct {
+	Added    []string
+	Modified []string
+	Removed  []string
+}
+
+// files returns the changed files of the given change types, see
+// parseChangeTypes
+func (c fileChanges) files(changeTypes []string) []string {
+	var files []string
+	for _, changeType := range changeTypes {
+		switch changeType {
+		case changeAdd:
+			files = append(files, c.Added...)
+		case changeModify:
+			files = append(files, c.Modified...)
+		case changeDelete:
+			files = append(files, c.Removed...)
+		}
+	}
+
+	return files
+}
+
+// setDefaultBranch sets the default branch sent by the webhook, an empty
+// branch keeps DefaultBranch
+func (w *WebhookRequest) setDefaultBranch(branch string) {
+	if branch != "" {
+		w.DefaultBranch = branch
+	}
+}
+
+// ref returns the branch of the push, or the tag with the tag: prefix, as
+// used in the mapping keys
+func (w *WebhookRequest) ref() string {
+	if w.Tag != "" {
+		return tagPrefix + w.Tag
+	}
+
+	return w.Branch
+}
+
+// errBranchDeleted is returned by the webhook parsers for pushes deleting
+// the branch
+var errBranchDeleted = errors.New("branch deleted")
+
+var (
+	errRepoMissing = errors.New("repo is missing")
+	errRefMissing  = errors.New("ref is missing")
+)
+
+// parseErrorReason returns the reason label of webhookParseErrors for an
+// error returned by the request parsers
+func parseErrorReason(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, errRepoMissing):
+		return "missing_repo"
+	case errors.Is(err, errRefMissing):
+		return "missing_ref"
+	case isBodyTooLarge(err):
+		return "body_too_large"
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "bad_json"
+	default:
+		return "other"
+	}
+}
+
+type githubPushEvent struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName      string `json:"full_name"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+	Commits []webhookCommit `json:"commits"`
+}
+
+// ParseGitHubWebhook parses the JSON body of a GitHub push webhook
+func ParseGitHubWebhook(r *http.Request) (*WebhookRequest, error) {
+	loggerFrom(r.Context()).Debug("Parsing github webhook")
+
+	return parseGitHubPushEvent(r)
+}
+
+// ParseGiteaWebhook parses the JSON body of a Gitea push webhook, which
+// follows the format of GitHub
+func ParseGiteaWebhook(r *http.Request) (*WebhookRequest, error) {
+	loggerFrom(r.Context()).Debug("Parsing gitea webhook")
+
+	return parseGitHubPushEvent(r)
+}
+
+func parseGitHubPushEvent(r *http.Request) (*WebhookRequest, error) {
+	var event githubPushEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		return nil, err
+	}
+
+	if event.Repository.FullName == "" {
+		return nil, errRepoMissing
+	}
+
+	if event.Ref == "" {
+		return nil, errRefMissing
+	}
+
+	repo := event.Repository.FullName
+	branch := branchFromRef(event.Ref)
+	files := collectChangedFiles(event.Commits)
+
+	logger := loggerFrom(r.Context())
+	logger.Debug("Parsed repo", "repo", repo)
+	logger.Debug("Parsed branch", "branch", branch)
+
+	if event.Deleted || isZeroCommit(event.After) {
+		return newWebhookRequest(r, repo, branch, []string{}), errBranchDeleted
+	}
+
+	webhook := newWebhookRequest(r, repo, branch, files)
+	webhook.Changes = collectFileChanges(event.Commits)
+	webhook.setDefaultBranch(event.Repository.DefaultBranch)
+
+	return webhook, nil
+}
+
+type gitlabPushEvent struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		DefaultBranch     string `json:"default_branch"`
+	} `json:"project"`
+	Commits []webhookCommit `json:"commits"`
+}
+
+// ParseGitLabWebhook parses the JSON body of a GitLab push hook
+func ParseGitLabWebhook(r *http.Request) (*WebhookRequest, error) {
+	logger := loggerFrom(r.Context())
+	logger.Debug("Parsing gitlab webhook")
+
+	var event gitlabPushEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		return nil, err
+	}
+
+	if event.Project.PathWithNamespace == "" {
+		return nil, errRepoMissing
+	}
+
+	if event.Ref == "" {
+		return nil, errRefMissing
+	}
+
+	repo := event.Project.PathWithNamespace
+	branch := branchFromRef(event.Ref)
+	files := collectChangedFiles(event.Commits)
+
+	logger.Debug("Parsed repo", "repo", repo)
+	logger.Debug("Parsed branch", "branch", branch)
+
+	if isZeroCommit(event.After) {
+		return newWebhookRequest(r, repo, branch, []string{}), errBranchDeleted
+	}
+
+	webhook := newWebhookRequest(r, repo, branch, files)
+	webhook.Changes = collectFileChanges(event.Commits)
+	webhook.setDefaultBranch(event.Project.DefaultBranch)
+
+	return webhook, nil
+}
+
+type bitbucketRefsChangedEvent struct {
+	Repository struct {
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+	} `json:"repository"`
+	Changes []struct {
+		Ref struct {
+			ID string `json:"id"`
+		} `json:"ref"`
+		Type string `json:"type"`
+	} `json:"changes"`
+}
+
+// ParseBitbucketWebhook parses the JSON body of a Bitbucket Server
+// repo:refs_changed webhook. The repo is given as project/slug, only the
+// first changed ref is considered and changed files are not part of the
+// payload.
+func ParseBitbucketWebhook(r *http.Request) (*WebhookRequest, error) {
+	logger := loggerFrom(r.Context())
+	logger.Debug("Parsing bitbucket webhook")
+
+	var event bitbucketRefsChangedEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		return nil, err
+	}
+
+	if event.Repository.Slug == "" || event.Repository.Project.Key == "" {
+		return nil, errRepoMissing
+	}
+
+	if len(event.Changes) == 0 || event.Changes[0].Ref.ID == "" {
+		return nil, errRefMissing
+	}
+
+	repo := event.Repository.Project.Key + "/" + event.Repository.Slug
+	branch := branchFromRef(event.Changes[0].Ref.ID)
+
+	logger.Debug("Parsed repo", "repo", repo)
+	logger.Debug("Parsed branch", "branch", branch)
+
+	if event.Changes[0].Type == "DELETE" {
+		return newWebhookRequest(r, repo, branch, []string{}), errBranchDeleted
+	}
+
+	return newWebhookRequest(r, repo, branch, []string{}), nil
+}
+
+// branchFromRef strips the refs/heads/ prefix from a git ref, tag refs are
+// returned with the tag: prefix to keep them apart from branches
+func branchFromRef(ref string) string {
+	if tag, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		return tagPrefix + tag
+	}
+
+	return strings.TrimPrefix(ref, "refs/heads/")
+}
+
+// normalizeRepo reduces the repo urls senders use, like
+// git@host:org/repo.git or https://host/org/repo, to the path org/repo
+func normalizeRepo(repo string) string {
+	repo = strings.TrimSuffix(strings.TrimSuffix(repo, "/"), ".git")
+
+	if _, rest, ok := strings.Cut(repo, "://"); ok {
+		if _, path, ok := strings.Cut(rest, "/"); ok {
+			return path
+		}
+		return repo
+	}
+
+	// scp like ssh urls, the colon has to come before the path
+	if host, path, ok := strings.Cut(repo, ":"); ok && !strings.Contains(host, "/") {
+		return path
+	}
+
+	return repo
+}
+
+// isZeroCommit reports whether sha is the all zero commit id webhooks send
+// as new revision of a deleted branch
+func isZeroCommit(sha string) bool {
+	return sha != "" && strings.Trim(sha, "0") == ""
+}
+
+// collectChangedFiles returns every file touched by the given commits, each
+// file only once and in the order of appearance
+func collectChangedFiles(commits []webhookCommit) []string {
+	var lists [][]string
+	for _, commit := range commits {
+		lists = append(lists, commit.Added, commit.Modified, commit.Removed)
+	}
+
+	return uniqueFiles(lists)
+}
+
+// collectFileChanges returns the files added, modified and removed by the
+// given commits, each file only once per change type
+func collectFileChanges(commits []webhookCommit) fileChanges {
+	var added, modified, removed [][]string
+	for _, commit := range commits {
+		added = append(added, commit.Added)
+		modified = append(modified, commit.Modified)
+		removed = append(removed, commit.Removed)
+	}
+
+	return fileChanges{Added: uniqueFiles(added), Modified: uniqueFiles(modified), Removed: uniqueFiles(removed)}
+}
+
+// uniqueFiles returns the files of all lists, each file only once and in
+// the order of appearance
+func uniqueFiles(lists [][]string) []string {
+	files := []string{}
+	seen := make(map[string]bool)
+
+	for _, list := range lists {
+		for _, file := range list {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// verifySignature checks the X-Hub-Signature-256 header value against the
+// HMAC-SHA256 of the body keyed with the given secret
+func verifySignature(body []byte, header, secret string) bool {
+	const prefix = "sha256="
+
+	if !strings.HasPrefix(header, prefix) {
+		return false
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, prefix))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(signature, mac.Sum(nil))
+}
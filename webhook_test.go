@@ -0,0 +1,731 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGitHubWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		want1   string
+		want2   []string
+		wantErr bool
+	}{
+		{
+			"push with commits",
+			`{"ref":"refs/heads/main","repository":{"full_name":"org/repo"},"commits":[` +
+				`{"added":["a.go"],"modified":["b.go"],"removed":[]},` +
+				`{"added":[],"modified":["b.go"],"removed":["c.go"]}]}`,
+			"org/repo",
+			"main",
+			[]string{"a.go", "b.go", "c.go"},
+			false,
+		},
+		{
+			"push with nested branch",
+			`{"ref":"refs/heads/feature/login","repository":{"full_name":"org/repo"}}`,
+			"org/repo",
+			"feature/login",
+			[]string{},
+			false,
+		},
+		{
+			"push of a tag",
+			`{"ref":"refs/tags/v1.2.3","repository":{"full_name":"org/repo"}}`,
+			"org/repo",
+			"tag:v1.2.3",
+			[]string{},
+			false,
+		},
+		{
+			"missing repo",
+			`{"ref":"refs/heads/main","repository":{}}`,
+			"",
+			"",
+			[]string{},
+			true,
+		},
+		{
+			"missing ref",
+			`{"repository":{"full_name":"org/repo"}}`,
+			"",
+			"",
+			[]string{},
+			true,
+		},
+		{
+			"invalid json",
+			`{"ref":`,
+			"",
+			"",
+			[]string{},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseGitHubWebhook(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseGitHubWebhook() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.Repo != tt.want {
+				t.Errorf("ParseGitHubWebhook() Repo = %v, want %v", got.Repo, tt.want)
+			}
+			if got.ref() != tt.want1 {
+				t.Errorf("ParseGitHubWebhook() ref() = %v, want %v", got.ref(), tt.want1)
+			}
+			if !reflect.DeepEqual(got.Files, tt.want2) {
+				t.Errorf("ParseGitHubWebhook() Files = %v, want %v", got.Files, tt.want2)
+			}
+		})
+	}
+}
+
+func TestParseGitLabWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		want1   string
+		want2   []string
+		wantErr bool
+	}{
+		{
+			"push with commits",
+			`{"ref":"refs/heads/main","project":{"path_with_namespace":"group/sub/repo"},"commits":[` +
+				`{"added":["a.go"],"modified":[],"removed":["c.go"]}]}`,
+			"group/sub/repo",
+			"main",
+			[]string{"a.go", "c.go"},
+			false,
+		},
+		{
+			"missing repo",
+			`{"ref":"refs/heads/main","project":{}}`,
+			"",
+			"",
+			[]string{},
+			true,
+		},
+		{
+			"missing ref",
+			`{"project":{"path_with_namespace":"group/repo"}}`,
+			"",
+			"",
+			[]string{},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseGitLabWebhook(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseGitLabWebhook() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.Repo != tt.want {
+				t.Errorf("ParseGitLabWebhook() Repo = %v, want %v", got.Repo, tt.want)
+			}
+			if got.ref() != tt.want1 {
+				t.Errorf("ParseGitLabWebhook() ref() = %v, want %v", got.ref(), tt.want1)
+			}
+			if !reflect.DeepEqual(got.Files, tt.want2) {
+				t.Errorf("ParseGitLabWebhook() Files = %v, want %v", got.Files, tt.want2)
+			}
+		})
+	}
+}
+
+func sign(body, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Test_verifySignature(t *testing.T) {
+	body := `{"ref":"refs/heads/main"}`
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"valid", sign(body, "secret"), true},
+		{"wrong secret", sign(body, "other"), false},
+		{"missing", "", false},
+		{"missing prefix", strings.TrimPrefix(sign(body, "secret"), "sha256="), false},
+		{"no hex", "sha256=zz", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifySignature([]byte(body), tt.header, "secret"); got != tt.want {
+				t.Errorf("verifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerSignature(t *testing.T) {
+	s := newTestServer(t)
+	s.mapping = map[string][]jobMapping{"org/repo|main": {{Name: "job1"}}}
+	s.config.QuietPeriod = 60 * time.Second
+	s.config.WebhookSecret = "secret"
+
+	body := `{"ref":"refs/heads/main","repository":{"full_name":"org/repo"}}`
+	gitlab := `{"ref":"refs/heads/main","project":{"path_with_namespace":"org/repo"}}`
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		event      string
+		value      string
+		header     string
+		wantStatus int
+		wantTimers int
+		wantErrors float64
+	}{
+		{"missing signature", "POST", "/", body, "X-GitHub-Event", "push", "", http.StatusUnauthorized, 0, 1},
+		{"wrong signature", "POST", "/", body, "X-GitHub-Event", "push", sign(body, "other"), http.StatusUnauthorized, 0, 1},
+		{"valid signature", "POST", "/", body, "X-GitHub-Event", "push", sign(body, "secret"), http.StatusOK, 1, 0},
+		{"unsigned get", "GET", "/?repo=org/repo&branch=main", "", "", "", "", http.StatusOK, 1, 0},
+		{"unsigned gitlab", "POST", "/", gitlab, "X-Gitlab-Event", "Push Hook", "", http.StatusOK, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stopTimers(s)
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.event != "" {
+				r.Header.Set(tt.event, tt.value)
+			}
+			if tt.header != "" {
+				r.Header.Set("X-Hub-Signature-256", tt.header)
+			}
+			badSignature := webhookParseErrors.sample([]string{"bad_signature"})
+			before := badSignature.value
+			w := httptest.NewRecorder()
+			s.handler(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("handler() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			s.timeKeeperMu.Lock()
+			timers := len(s.timeKeeper)
+			s.timeKeeperMu.Unlock()
+			if timers != tt.wantTimers {
+				t.Errorf("handler() created %d timers, want %d", timers, tt.wantTimers)
+			}
+			if got := badSignature.value - before; got != tt.wantErrors {
+				t.Errorf("handler() counted %v bad signatures, want %v", got, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestHandlerMaxBodyBytes(t *testing.T) {
+	s := newTestServer(t)
+	s.mapping = map[string][]jobMapping{"org/repo|main": {{Name: "job1"}}}
+	s.config.QuietPeriod = 60 * time.Second
+	s.config.MaxBodyBytes = 128
+
+	body := `{"ref":"refs/heads/main","repository":{"full_name":"org/repo"}}`
+	large := `{"ref":"refs/heads/main","repository":{"full_name":"org/repo"},"padding":"` + strings.Repeat("x", 256) + `"}`
+	tests := []struct {
+		name       string
+		secret     string
+		body       string
+		wantStatus int
+	}{
+		{"small body", "", body, http.StatusOK},
+		{"large body", "", large, http.StatusRequestEntityTooLarge},
+		{"large signed body", "secret", large, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.config.WebhookSecret = tt.secret
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.Header.Set("X-GitHub-Event", "push")
+			if tt.secret != "" {
+				r.Header.Set("X-Hub-Signature-256", sign(tt.body, tt.secret))
+			}
+			w := httptest.NewRecorder()
+			s.handler(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("handler() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestParseGiteaWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		want1   string
+		want2   []string
+		wantErr bool
+	}{
+		{
+			"push with commits",
+			`{"ref":"refs/heads/develop","repository":{"full_name":"org/repo"},"commits":[` +
+				`{"added":["docs/a.md"],"modified":["src/b.go"],"removed":[]}]}`,
+			"org/repo",
+			"develop",
+			[]string{"docs/a.md", "src/b.go"},
+			false,
+		},
+		{
+			"missing repo",
+			`{"ref":"refs/heads/main"}`,
+			"",
+			"",
+			[]string{},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			got, err := ParseGiteaWebhook(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseGiteaWebhook() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.Repo != tt.want {
+				t.Errorf("ParseGiteaWebhook() Repo = %v, want %v", got.Repo, tt.want)
+			}
+			if got.ref() != tt.want1 {
+				t.Errorf("ParseGiteaWebhook() ref() = %v, want %v", got.ref(), tt.want1)
+			}
+			if !reflect.DeepEqual(got.Files, tt.want2) {
+				t.Errorf("ParseGiteaWebhook() Files = %v, want %v", got.Files, tt.want2)
+			}
+		})
+	}
+}
+
+func TestHandlerGitea(t *testing.T) {
+	s := newTestServer(t)
+	tm, err := ParseMappingFile(strings.NewReader("org/repo;main;job1;src/"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mapping = tm.mapping
+	s.config.QuietPeriod = 60 * time.Second
+
+	tests := []struct {
+		name       string
+		file       string
+		wantStatus int
+	}{
+		{"matching file", "src/main.go", http.StatusOK},
+		{"other file", "docs/README.md", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"ref":"refs/heads/main","repository":{"full_name":"org/repo"},"commits":[{"modified":["` + tt.file + `"]}]}`
+			r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			r.Header.Set("X-Gitea-Event", "push")
+			r.Header.Set("X-GitHub-Event", "push")
+			w := httptest.NewRecorder()
+			s.handler(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("handler() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHandlerChangeTypes(t *testing.T) {
+	s := newTestServer(t)
+	tm, err := ParseMappingFile(strings.NewReader("org/docs;main;build;^docs/;;;;;add,modify\norg/docs;main;cleanup;^docs/;;;;;delete\norg/docs;main;lint;\n"+
+		"org/docs;main;publish;^site/;;;;;add\norg/docs;main;publish;^docs/;;;;;delete"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mapping = tm.mapping
+	s.config.QuietPeriod = 60 * time.Second
+
+	tests := []struct {
+		name    string
+		commits string
+		want    []string
+	}{
+		{"added", `{"added":["docs/a.md"]}`, []string{"build", "lint"}},
+		{"modified", `{"modified":["docs/a.md"]}`, []string{"build", "lint"}},
+		{"removed", `{"removed":["docs/a.md"]}`, []string{"cleanup", "lint", "publish"}},
+		{"added and removed", `{"added":["docs/a.md"],"removed":["docs/b.md"]}`, []string{"build", "cleanup", "lint", "publish"}},
+		{"added site", `{"added":["site/index.html"]}`, []string{"lint", "publish"}},
+		{"modified site", `{"modified":["site/index.html"]}`, []string{"lint"}},
+		{"removed elsewhere", `{"added":["docs/a.md"],"removed":["src/b.go"]}`, []string{"build", "lint"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"ref":"refs/heads/main","repository":{"full_name":"org/docs"},"commits":[` + tt.commits + `]}`
+			r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			r.Header.Set("X-GitHub-Event", "push")
+			w := httptest.NewRecorder()
+			s.handler(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("handler() status = %v, want %v", w.Code, http.StatusOK)
+			}
+			var resp triggerResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, job := range resp.Jobs {
+				got = append(got, job.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("handler() jobs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerDefaultBranch(t *testing.T) {
+	s := newTestServer(t)
+	tm, err := ParseMappingFile(strings.NewReader("org/repo;@default;release"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mapping = tm.mapping
+	s.branchPatterns = tm.patterns
+	s.config.QuietPeriod = 60 * time.Second
+
+	tests := []struct {
+		name       string
+		body       string
+		event      string
+		value      string
+		wantStatus int
+	}{
+		{
+			"github default branch",
+			`{"ref":"refs/heads/trunk","repository":{"full_name":"org/repo","default_branch":"trunk"}}`,
+			"X-GitHub-Event", "push",
+			http.StatusOK,
+		},
+		{
+			"github other branch",
+			`{"ref":"refs/heads/master","repository":{"full_name":"org/repo","default_branch":"trunk"}}`,
+			"X-GitHub-Event", "push",
+			http.StatusNotFound,
+		},
+		{
+			"gitlab default branch",
+			`{"ref":"refs/heads/trunk","project":{"path_with_namespace":"org/repo","default_branch":"trunk"}}`,
+			"X-Gitlab-Event", "Push Hook",
+			http.StatusOK,
+		},
+		{
+			"configured default branch",
+			`{"ref":"refs/heads/master","repository":{"full_name":"org/repo"}}`,
+			"X-GitHub-Event", "push",
+			http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.Header.Set(tt.event, tt.value)
+			w := httptest.NewRecorder()
+			s.handler(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("handler() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHandlerBranchDeleted(t *testing.T) {
+	s := newTestServer(t)
+	s.mapping = map[string][]jobMapping{
+		"org/repo|feature": {{Name: "job1"}},
+		"PRJ/repo|feature": {{Name: "job1"}},
+	}
+	s.config.QuietPeriod = 60 * time.Second
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		body   string
+	}{
+		{"github deleted", "X-GitHub-Event", "push",
+			`{"ref":"refs/heads/feature","deleted":true,"repository":{"full_name":"org/repo"}}`},
+		{"gitea zero commit", "X-Gitea-Event", "push",
+			`{"ref":"refs/heads/feature","after":"0000000000000000000000000000000000000000","repository":{"full_name":"org/repo"}}`},
+		{"gitlab zero commit", "X-Gitlab-Event", "Push Hook",
+			`{"ref":"refs/heads/feature","after":"0000000000000000000000000000000000000000","project":{"path_with_namespace":"org/repo"}}`},
+		{"bitbucket delete", "X-Event-Key", "repo:refs_changed",
+			`{"repository":{"slug":"repo","project":{"key":"PRJ"}},"changes":[{"ref":{"id":"refs/heads/feature"},"type":"DELETE"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+			s.handler(w, r)
+			if w.Code != http.StatusOK {
+				t.Errorf("handler() status = %v, want %v", w.Code, http.StatusOK)
+			}
+			if got, want := w.Body.String(), "branch feature deleted, skipping\n"; got != want {
+				t.Errorf("handler() body = %q, want %q", got, want)
+			}
+
+			s.timeKeeperMu.Lock()
+			timers := len(s.timeKeeper)
+			s.timeKeeperMu.Unlock()
+			if timers != 0 {
+				t.Errorf("handler() created %d timers for a deleted branch", timers)
+			}
+		})
+	}
+}
+
+func TestHandlerTagPush(t *testing.T) {
+	s := newTestServer(t)
+	s.mapping = map[string][]jobMapping{
+		"org/repo|v1.2.3":     {{Name: "branch-job"}},
+		"org/repo|tag:v1.2.3": {{Name: "release"}},
+		"PRJ/repo|tag:v1.2.3": {{Name: "release"}},
+	}
+	s.config.QuietPeriod = 60 * time.Second
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		body   string
+	}{
+		{"github", "X-GitHub-Event", "push",
+			`{"ref":"refs/tags/v1.2.3","after":"abc123","repository":{"full_name":"org/repo"}}`},
+		{"gitlab", "X-Gitlab-Event", "Tag Push Hook",
+			`{"ref":"refs/tags/v1.2.3","after":"abc123","project":{"path_with_namespace":"org/repo"}}`},
+		{"bitbucket", "X-Event-Key", "repo:refs_changed",
+			`{"repository":{"slug":"repo","project":{"key":"PRJ"}},"changes":[{"ref":{"id":"refs/tags/v1.2.3"},"type":"ADD"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stopTimers(s)
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+			s.handler(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("handler() status = %v, want %v: %s", w.Code, http.StatusOK, w.Body)
+			}
+
+			s.timeKeeperMu.Lock()
+			defer s.timeKeeperMu.Unlock()
+			if _, ok := s.timeKeeper["release"]; !ok || len(s.timeKeeper) != 1 {
+				t.Errorf("handler() scheduled %v, want only release", s.timeKeeper)
+			}
+		})
+	}
+}
+
+func TestParseBitbucketWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		want1   string
+		wantErr bool
+	}{
+		{
+			"refs changed",
+			`{"eventKey":"repo:refs_changed","repository":{"slug":"repo","project":{"key":"PRJ"}},` +
+				`"changes":[{"ref":{"id":"refs/heads/feature/x","displayId":"feature/x","type":"BRANCH"},"type":"UPDATE"}]}`,
+			"PRJ/repo",
+			"feature/x",
+			false,
+		},
+		{
+			"missing project",
+			`{"repository":{"slug":"repo"},"changes":[{"ref":{"id":"refs/heads/master"}}]}`,
+			"",
+			"",
+			true,
+		},
+		{
+			"no changes",
+			`{"repository":{"slug":"repo","project":{"key":"PRJ"}},"changes":[]}`,
+			"",
+			"",
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseBitbucketWebhook(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseBitbucketWebhook() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.Repo != tt.want {
+				t.Errorf("ParseBitbucketWebhook() Repo = %v, want %v", got.Repo, tt.want)
+			}
+			if got.ref() != tt.want1 {
+				t.Errorf("ParseBitbucketWebhook() ref() = %v, want %v", got.ref(), tt.want1)
+			}
+		})
+	}
+}
+
+func Test_newWebhookRequest(t *testing.T) {
+	r, err := http.NewRequest("GET", "/?repo=org/repo&sha=abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ref  string
+		want *WebhookRequest
+	}{
+		{
+			"branch",
+			"main",
+			&WebhookRequest{Repo: "org/repo", Branch: "main", DefaultBranch: "master", Files: []string{},
+				Params: url.Values{"SHA": {"abc"}, "BRANCH": {"main"}}},
+		},
+		{
+			"tag",
+			"tag:v1.2.3",
+			&WebhookRequest{Repo: "org/repo", Tag: "v1.2.3", DefaultBranch: "master", Files: []string{},
+				Params: url.Values{"SHA": {"abc"}, "TAG": {"v1.2.3"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newWebhookRequest(r, "org/repo", tt.ref, []string{})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newWebhookRequest() = %+v, want %+v", got, tt.want)
+			}
+			if got.ref() != tt.ref {
+				t.Errorf("ref() = %v, want %v", got.ref(), tt.ref)
+			}
+		})
+	}
+}
+
+func Test_parseErrorReason(t *testing.T) {
+	parse := func(body string) error {
+		_, err := ParseGitHubWebhook(httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		return err
+	}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"missing repo", parse(`{"ref":"refs/heads/main"}`), "missing_repo"},
+		{"missing ref", parse(`{"repository":{"full_name":"org/repo"}}`), "missing_ref"},
+		{"truncated json", parse(`{"ref":`), "bad_json"},
+		{"empty body", parse(``), "bad_json"},
+		{"wrong type", parse(`{"ref":1}`), "bad_json"},
+		{"body too large", &http.MaxBytesError{Limit: 1}, "body_too_large"},
+		{"other", errors.New("boom"), "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseErrorReason(tt.err); got != tt.want {
+				t.Errorf("parseErrorReason(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_normalizeRepo(t *testing.T) {
+	tests := []struct {
+		repo string
+		want string
+	}{
+		{"org/repo", "org/repo"},
+		{"org/repo.git", "org/repo"},
+		{"git@github.com:org/repo.git", "org/repo"},
+		{"github.com:org/repo", "org/repo"},
+		{"https://github.com/org/repo", "org/repo"},
+		{"https://github.com/org/repo.git/", "org/repo"},
+		{"https://gitlab.example.com/group/sub/repo.git", "group/sub/repo"},
+		{"ssh://git@host:2222/org/repo.git", "org/repo"},
+		{"git://host/org/repo", "org/repo"},
+		{"https://host", "https://host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			if got := normalizeRepo(tt.repo); got != tt.want {
+				t.Errorf("normalizeRepo(%q) = %v, want %v", tt.repo, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerNormalizeRepo(t *testing.T) {
+	s := newTestServer(t)
+	s.mapping = map[string][]jobMapping{"org/repo|main": {{Name: "job1"}}}
+	s.config.QuietPeriod = 60 * time.Second
+	s.config.NormalizeRepo = true
+
+	body := `{"ref":"refs/heads/main","repository":{"full_name":"git@github.com:org/repo.git"}}`
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("X-GitHub-Event", "push")
+	w := httptest.NewRecorder()
+	s.handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("handler() status = %v, want %v, body %q", w.Code, http.StatusOK, w.Body.String())
+	}
+}
+
+func Test_collectFileChanges(t *testing.T) {
+	commits := []webhookCommit{
+		{Added: []string{"a.go"}, Modified: []string{"b.go"}},
+		{Modified: []string{"b.go", "a.go"}, Removed: []string{"c.go"}},
+	}
+	want := fileChanges{Added: []string{"a.go"}, Modified: []string{"b.go", "a.go"}, Removed: []string{"c.go"}}
+	if got := collectFileChanges(commits); !reflect.DeepEqual(got, want) {
+		t.Errorf("collectFileChanges() = %+v, want %+v", got, want)
+	}
+	if got := want.files([]string{changeAdd, changeDelete}); !reflect.DeepEqual(got, []string{"a.go", "c.go"}) {
+		t.Errorf("files() = %v, want [a.go c.go]", got)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// ParseYAMLMappingFile parses a yaml mapping file and returns the mapping.
+// The file holds a list of entries with the keys repo, branch, job,
+// filematch, params, quietperiod, jenkins, endpoint and change.
+func ParseYAMLMappingFile(file io.Reader, filematch bool) (triggerMapping, error) {
+	tm := triggerMapping{mapping: make(map[string][]jobMapping)}
+
+	items, err := parseYAMLList(file)
+	if err != nil {
+		return triggerMapping{mapping: nil}, err
+	}
+
+	var errs []error
+	for _, item := range items {
+		var entry mappingEntry
+		hasFileMatch := false
+		var entryErrs []error
+
+		for key, value := range item.values {
+			switch key {
+			case "repo":
+				entry.Repo = value
+			case "branch":
+				entry.Branch = value
+			case "job":
+				entry.Job = value
+			case "filematch":
+				entry.FileMatch = value
+				hasFileMatch = true
+			case "params":
+				entry.Params = value
+			case "quietperiod":
+				entry.QuietPeriod = value
+			case "jenkins":
+				entry.Jenkins = value
+			case "endpoint":
+				entry.Endpoint = value
+			case "change":
+				entry.Change = value
+			default:
+				entryErrs = append(entryErrs, fmt.Errorf("line %d: unknown key %q", item.line, key))
+			}
+		}
+
+		if err := entry.validate(); err != nil {
+			entryErrs = append(entryErrs, fmt.Errorf("line %d: %v", item.line, err))
+		}
+
+		if filematch && !hasFileMatch {
+			entryErrs = append(entryErrs, fmt.Errorf("line %d: no file matching information provided in mapping file", item.line))
+		}
+
+		if len(entryErrs) == 0 {
+			if err := tm.add(entry, filematch); err != nil {
+				entryErrs = append(entryErrs, fmt.Errorf("line %d: %v", item.line, err))
+			}
+		}
+
+		errs = append(errs, entryErrs...)
+	}
+
+	if len(errs) > 0 {
+		return triggerMapping{mapping: nil}, errors.Join(errs...)
+	}
+
+	slog.Debug("Successfully read mappings", "mappings", len(items))
+
+	return tm, nil
+}
+
+// yamlItem is an entry of a yaml list
+type yamlItem struct {
+	// line is the line the entry starts at
+	line   int
+	values map[string]string
+}
+
+// parseYAMLList parses the subset of yaml used by mapping files: a list of
+// flat maps with scalar values, comments and blank lines
+func parseYAMLList(r io.Reader) ([]yamlItem, error) {
+	var items []yamlItem
+	var item map[string]string
+	itemIndent := 0
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(stripYAMLComment(scanner.Text()), " \t")
+
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+
+		indent := len(line) - len(trimmed)
+
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			item = make(map[string]string)
+			items = append(items, yamlItem{line: lineNumber, values: item})
+			trimmed = strings.TrimLeft(strings.TrimPrefix(trimmed, "-"), " ")
+			itemIndent = indent
+			if trimmed == "" {
+				continue
+			}
+		} else if item == nil || indent <= itemIndent {
+			return nil, fmt.Errorf("line %d: expected a list entry", lineNumber)
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNumber)
+		}
+
+		key = strings.TrimSpace(key)
+		if _, ok := item[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", lineNumber, key)
+		}
+
+		value, err := unquoteYAML(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+
+		item[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// stripYAMLComment removes a trailing comment which is not part of a
+// quoted value
+func stripYAMLComment(line string) string {
+	var quote rune
+
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+func unquoteYAML(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", errors.New("unterminated quoted value")
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+
+	return value, nil
+}
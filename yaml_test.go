@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseYAMLMappingFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		filematch bool
+		want      triggerMapping
+		wantErr   bool
+	}{
+		{
+			"two_entries",
+			`# team A
+- repo: git://reposerver/repo
+  branch: branch
+  job: job
+
+- repo: "git://reposerver/repo"  # same repo
+  branch: 'branch'
+  job: job2
+  params: BRANCH,SHA
+`,
+			false,
+			triggerMapping{mapping: map[string][]jobMapping{
+				"git://reposerver/repo|branch": {{Name: "job"}, {Name: "job2", Params: []string{"BRANCH", "SHA"}}},
+			}},
+			false,
+		},
+		{
+			"filematch",
+			"- repo: git://reposerver/repo\n  branch: branch\n  job: job\n  filematch: repo\n",
+			true,
+			triggerMapping{mapping: map[string][]jobMapping{
+				"git://reposerver/repo|branch": {{Name: "job", Rules: []fileRule{{FilePatterns: []*regexp.Regexp{regexp.MustCompile("repo")}}}}},
+			}},
+			false,
+		},
+		{
+			"change",
+			"- repo: git://reposerver/repo\n  branch: branch\n  job: cleanup\n  change: delete\n",
+			false,
+			triggerMapping{mapping: map[string][]jobMapping{
+				"git://reposerver/repo|branch": {{Name: "cleanup", Rules: []fileRule{{ChangeTypes: []string{"delete"}}}}},
+			}},
+			false,
+		},
+		{
+			"filematch_missing",
+			"- repo: git://reposerver/repo\n  branch: branch\n  job: job\n",
+			true,
+			triggerMapping{mapping: nil},
+			true,
+		},
+		{
+			"unknown_key",
+			"- repo: git://reposerver/repo\n  brnach: branch\n  job: job\n",
+			false,
+			triggerMapping{mapping: nil},
+			true,
+		},
+		{
+			"missing_job",
+			"- repo: git://reposerver/repo\n  branch: branch\n",
+			false,
+			triggerMapping{mapping: nil},
+			true,
+		},
+		{
+			"no_list",
+			"repo: git://reposerver/repo\n",
+			false,
+			triggerMapping{mapping: nil},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseYAMLMappingFile(strings.NewReader(tt.file), tt.filematch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseYAMLMappingFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseYAMLMappingFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLMappingFileReportsLines(t *testing.T) {
+	file := strings.NewReader(strings.Join([]string{
+		"# team A",
+		"- repo: git://reposerver/repo",
+		"  branch: branch",
+		"  job: job",
+		"",
+		"- repo: git://reposerver/repo",
+		"  brnach: branch",
+		"  job: job2",
+		"-",
+		"  repo: git://reposerver/repo",
+		"  branch: branch",
+	}, "\n"))
+
+	_, err := ParseYAMLMappingFile(file, false)
+	if err == nil {
+		t.Fatal("ParseYAMLMappingFile() expected error")
+	}
+	for _, want := range []string{`line 6: unknown key "brnach"`, "line 9: "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseYAMLMappingFile() error does not contain %q:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "line 2:") {
+		t.Errorf("ParseYAMLMappingFile() reported the valid entry:\n%v", err)
+	}
+}